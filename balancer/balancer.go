@@ -0,0 +1,343 @@
+// Package balancer spreads calls of a replicated service over its
+// endpoints, instead of relying on a proxy in front of it.
+//
+// A Balancer refreshes the endpoints from a Resolver, picks one per call by
+// its Policy and ejects endpoints for a while after consecutive failures.
+//
+// client/http balances requests to a logical service name, e.g.
+//
+//	b, err := balancer.New("user-service", balancer.DNS("user-service-headless", 8080),
+//		balancer.Options{Policy: balancer.LeastRequests})
+//	c, err := http.NewClient(http.Options{
+//		Services: map[string]*balancer.Balancer{"user-service": b},
+//	})
+//	data, err := c.Get(ctx, "http://user-service/users/1")
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
+)
+
+const (
+	defaultRefresh             = 30 * time.Second
+	defaultConsecutiveFailures = 5
+	defaultEjectionTime        = 30 * time.Second
+	defaultMaxEjectionPercent  = 50
+)
+
+// ErrNoEndpoints is returned by Pick when the resolver found no endpoints.
+var ErrNoEndpoints = errors.New("balancer has no endpoints")
+
+// Policy selects the endpoint of a call.
+type Policy int
+
+const (
+	// RoundRobin takes the endpoints in turn.
+	RoundRobin Policy = iota
+	// LeastRequests takes the endpoint with the fewest calls in flight.
+	LeastRequests
+	// PowerOfTwoChoices takes the endpoint with fewer calls in flight of
+	// two picked at random.
+	PowerOfTwoChoices
+)
+
+func (p Policy) String() string {
+	switch p {
+	case RoundRobin:
+		return "round-robin"
+	case LeastRequests:
+		return "least-requests"
+	case PowerOfTwoChoices:
+		return "p2c"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// Options configures a Balancer. Zero values take the defaults.
+type Options struct {
+	// Policy selects the endpoint of a call, RoundRobin by default.
+	Policy Policy
+
+	// Refresh is the interval of resolving the endpoints again, 30s by
+	// default. Negative disables refreshing.
+	Refresh time.Duration
+
+	// ConsecutiveFailures ejects an endpoint after this many failed calls
+	// in a row, 5 by default. Negative disables ejection.
+	ConsecutiveFailures int
+
+	// EjectionTime is how long an endpoint stays ejected, 30s by default.
+	EjectionTime time.Duration
+
+	// MaxEjectionPercent limits the share of endpoints ejected at the same
+	// time, 50 by default. An ejection which exceeds it is skipped.
+	MaxEjectionPercent int
+
+	// IsFailure decides whether the error of a call counts as failure, any
+	// non-nil error by default.
+	IsFailure func(err error) bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Refresh == 0 {
+		o.Refresh = defaultRefresh
+	}
+	if o.ConsecutiveFailures == 0 {
+		o.ConsecutiveFailures = defaultConsecutiveFailures
+	}
+	if o.EjectionTime <= 0 {
+		o.EjectionTime = defaultEjectionTime
+	}
+	if o.MaxEjectionPercent <= 0 {
+		o.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+	if o.IsFailure == nil {
+		o.IsFailure = func(err error) bool { return err != nil }
+	}
+	return o
+}
+
+type endpoint struct {
+	addr         string
+	inFlight     int
+	failures     int
+	ejectedUntil time.Time
+}
+
+// Balancer picks endpoints of a service, safe for concurrent use.
+type Balancer struct {
+	name     string
+	resolver Resolver
+	opts     Options
+	cancel   context.CancelFunc
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	rand      *rand.Rand
+}
+
+// New creates a Balancer of the endpoints found by r, which must find at
+// least one. name identifies it in logs. Close stops refreshing.
+func New(name string, r Resolver, opts Options) (*Balancer, error) {
+	opts = opts.withDefaults()
+	b := &Balancer{
+		name:     name,
+		resolver: r,
+		opts:     opts,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	timeout := opts.Refresh
+	if timeout <= 0 {
+		timeout = defaultRefresh
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	addrs, err := r.Resolve(ctx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("balancer %s: %w", name, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("balancer %s: %w", name, ErrNoEndpoints)
+	}
+	b.update(addrs)
+
+	ctx, b.cancel = context.WithCancel(context.Background())
+	if opts.Refresh > 0 {
+		go b.refresh(ctx)
+	}
+	return b, nil
+}
+
+// Name returns the name of the balancer.
+func (b *Balancer) Name() string {
+	return b.name
+}
+
+// Close stops refreshing the endpoints.
+func (b *Balancer) Close() error {
+	b.cancel()
+	return nil
+}
+
+// Endpoints returns the current endpoints, ejected ones included.
+func (b *Balancer) Endpoints() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	addrs := make([]string, len(b.endpoints))
+	for i, e := range b.endpoints {
+		addrs[i] = e.addr
+	}
+	return addrs
+}
+
+// Pick returns the endpoint of a call, other than the exclude ones when
+// possible, e.g. those already tried by the call. The returned function must
+// be called with the outcome of the call.
+func (b *Balancer) Pick(exclude ...string) (string, func(err error), error) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.endpoints) == 0 {
+		return "", nil, ErrNoEndpoints
+	}
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if !now.Before(e.ejectedUntil) && !contains(exclude, e.addr) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 && len(exclude) > 0 {
+		// better an endpoint tried already than an ejected one
+		for _, e := range b.endpoints {
+			if !now.Before(e.ejectedUntil) {
+				candidates = append(candidates, e)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		// better an ejected endpoint than none
+		candidates = b.endpoints
+	}
+
+	var e *endpoint
+	switch b.opts.Policy {
+	case LeastRequests:
+		// start in turn so ties are spread
+		b.next++
+		for i := range candidates {
+			c := candidates[(b.next+i)%len(candidates)]
+			if e == nil || c.inFlight < e.inFlight {
+				e = c
+			}
+		}
+	case PowerOfTwoChoices:
+		e = candidates[b.rand.Intn(len(candidates))]
+		if len(candidates) > 1 {
+			i := b.rand.Intn(len(candidates) - 1)
+			if candidates[i] == e {
+				i = len(candidates) - 1
+			}
+			if c := candidates[i]; c.inFlight < e.inFlight {
+				e = c
+			}
+		}
+	default:
+		b.next++
+		e = candidates[b.next%len(candidates)]
+	}
+	e.inFlight++
+
+	return e.addr, func(err error) {
+		b.done(e, b.opts.IsFailure(err))
+	}, nil
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Balancer) done(e *endpoint, failed bool) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.inFlight--
+	if !failed {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if b.opts.ConsecutiveFailures < 0 || e.failures < b.opts.ConsecutiveFailures || now.Before(e.ejectedUntil) {
+		return
+	}
+	ejected := 0
+	for _, o := range b.endpoints {
+		if now.Before(o.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(b.endpoints)*b.opts.MaxEjectionPercent {
+		return
+	}
+	e.failures = 0
+	e.ejectedUntil = now.Add(b.opts.EjectionTime)
+	log.Warn("balancer endpoint ejected",
+		zap.String("name", b.name),
+		zap.String("endpoint", e.addr),
+		zap.Duration("duration", b.opts.EjectionTime))
+}
+
+func (b *Balancer) refresh(ctx context.Context) {
+	ticker := time.NewTicker(b.opts.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rctx, cancel := context.WithTimeout(ctx, b.opts.Refresh)
+		addrs, err := b.resolver.Resolve(rctx)
+		cancel()
+		if err == nil && len(addrs) == 0 {
+			err = ErrNoEndpoints
+		}
+		if err != nil {
+			// keep the last endpoints
+			log.Warn("balancer resolve failed",
+				zap.String("name", b.name),
+				zap.Error(err))
+			continue
+		}
+		b.update(addrs)
+	}
+}
+
+// update replaces the endpoints by addrs, keeping the state of those which
+// remain.
+func (b *Balancer) update(addrs []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old := make(map[string]*endpoint, len(b.endpoints))
+	for _, e := range b.endpoints {
+		old[e.addr] = e
+	}
+	endpoints := make([]*endpoint, 0, len(addrs))
+	changed := len(addrs) != len(b.endpoints)
+	for _, addr := range addrs {
+		e, ok := old[addr]
+		if !ok {
+			e = &endpoint{addr: addr}
+			changed = true
+		}
+		delete(old, addr)
+		endpoints = append(endpoints, e)
+	}
+	b.endpoints = endpoints
+	if changed {
+		log.Info("balancer endpoints changed",
+			zap.String("name", b.name),
+			zap.Strings("endpoints", addrs))
+	}
+}
@@ -0,0 +1,163 @@
+package balancer
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+func newTestBalancer(t *testing.T, r Resolver, opts Options) *Balancer {
+	b, err := New("test", r, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+func pick(t *testing.T, b *Balancer) (string, func(error)) {
+	addr, done, err := b.Pick()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr, done
+}
+
+func TestRoundRobin(t *testing.T) {
+	b := newTestBalancer(t, Static("a:1", "b:1", "c:1"), Options{})
+	seen := map[string]int{}
+	for i := 0; i < 9; i++ {
+		addr, done := pick(t, b)
+		done(nil)
+		seen[addr]++
+	}
+	if !reflect.DeepEqual(seen, map[string]int{"a:1": 3, "b:1": 3, "c:1": 3}) {
+		t.Errorf("picks %v, want each endpoint 3 times", seen)
+	}
+}
+
+func TestLeastRequests(t *testing.T) {
+	b := newTestBalancer(t, Static("a:1", "b:1", "c:1"), Options{Policy: LeastRequests})
+	busy := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		addr, _ := pick(t, b)
+		if busy[addr] {
+			t.Fatalf("picked busy endpoint %s while others were idle", addr)
+		}
+		busy[addr] = true
+	}
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	b := newTestBalancer(t, Static("a:1", "b:1"), Options{Policy: PowerOfTwoChoices})
+	busy, _ := pick(t, b)
+	// with two endpoints both are compared, the idle one always wins
+	for i := 0; i < 20; i++ {
+		addr, done := pick(t, b)
+		if addr == busy {
+			t.Fatalf("picked busy endpoint %s", addr)
+		}
+		done(nil)
+	}
+}
+
+func TestPickExclude(t *testing.T) {
+	for _, policy := range []Policy{RoundRobin, LeastRequests, PowerOfTwoChoices} {
+		b := newTestBalancer(t, Static("a:1", "b:1"), Options{Policy: policy})
+		for i := 0; i < 10; i++ {
+			addr, done, err := b.Pick("a:1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			done(nil)
+			if addr != "b:1" {
+				t.Fatalf("%v picked excluded endpoint %s", policy, addr)
+			}
+		}
+		// excluding all endpoints still picks one
+		addr, done, err := b.Pick("a:1", "b:1")
+		if err != nil || addr == "" {
+			t.Fatalf("%v pick excluding all = %q, %v", policy, addr, err)
+		}
+		done(nil)
+	}
+}
+
+func TestEjectionAndReadmission(t *testing.T) {
+	b := newTestBalancer(t, Static("a:1", "b:1"), Options{
+		ConsecutiveFailures: 2,
+		EjectionTime:        50 * time.Millisecond,
+	})
+	for failures := 0; failures < 2; {
+		addr, done := pick(t, b)
+		if addr == "a:1" {
+			done(errDown)
+			failures++
+		} else {
+			done(nil)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		addr, done := pick(t, b)
+		if addr == "a:1" {
+			t.Fatal("picked ejected endpoint")
+		}
+		// b may not be ejected as well, that exceeds MaxEjectionPercent
+		done(errDown)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		addr, done := pick(t, b)
+		done(nil)
+		seen[addr] = true
+	}
+	if !seen["a:1"] || !seen["b:1"] {
+		t.Errorf("picks %v after ejection time, want both endpoints", seen)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints")
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("# user-service\na:1\n\nb:1\n")
+
+	b := newTestBalancer(t, File(path), Options{Refresh: 10 * time.Millisecond})
+	if got := b.Endpoints(); !reflect.DeepEqual(got, []string{"a:1", "b:1"}) {
+		t.Fatalf("endpoints %v, want [a:1 b:1]", got)
+	}
+
+	write("a:1\nb:1\nc:1\n")
+	waitEndpoints(t, b, []string{"a:1", "b:1", "c:1"})
+
+	// a failed resolve keeps the last endpoints
+	write("")
+	time.Sleep(50 * time.Millisecond)
+	if got := b.Endpoints(); !reflect.DeepEqual(got, []string{"a:1", "b:1", "c:1"}) {
+		t.Errorf("endpoints %v after empty file, want the last ones", got)
+	}
+}
+
+func waitEndpoints(t *testing.T, b *Balancer, want []string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := b.Endpoints()
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoints %v, want %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,112 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver looks up the endpoints of a service as "host:port" addresses.
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc func(ctx context.Context) ([]string, error)
+
+// Resolve calls f.
+func (f ResolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// Static resolves to the fixed addrs.
+func Static(addrs ...string) Resolver {
+	return ResolverFunc(func(context.Context) ([]string, error) {
+		return addrs, nil
+	})
+}
+
+// DNS resolves the A and AAAA records of host, with port appended to each
+// address, e.g. a headless Kubernetes service.
+func DNS(host string, port int) Resolver {
+	p := strconv.Itoa(port)
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.JoinHostPort(ip, p)
+		}
+		return addrs, nil
+	})
+}
+
+// SRV resolves the SRV records of _service._proto.name, or of name when
+// service and proto are empty, to their targets and ports.
+func SRV(service, proto, name string) Resolver {
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, len(records))
+		for i, r := range records {
+			addrs[i] = net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+		}
+		return addrs, nil
+	})
+}
+
+// File resolves to the addresses listed in the file at path, one per line.
+// Empty lines and lines starting with # are skipped. The file is only read
+// again when its modification time or size changed, so it can be watched
+// with a short Options.Refresh.
+func File(path string) Resolver {
+	return &fileResolver{path: path}
+}
+
+type fileResolver struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	addrs   []string
+}
+
+func (r *fileResolver) Resolve(context.Context) ([]string, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.addrs != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return r.addrs, nil
+	}
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	addrs := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	r.modTime, r.size, r.addrs = info.ModTime(), info.Size(), addrs
+	return addrs, nil
+}
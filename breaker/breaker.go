@@ -0,0 +1,330 @@
+// Package breaker implements circuit breakers which stop calling a failing
+// dependency for a while instead of waiting for its timeouts.
+//
+// A Breaker is closed while calls succeed. When the failure rate or the
+// slow call rate within a rolling window exceeds its threshold the breaker
+// opens and calls fail fast with ErrOpen. After OpenTimeout it lets a few
+// trial calls through half-open, and closes again when they succeed.
+//
+// client/http uses one breaker per host. Other calls are wrapped with Do,
+// e.g. for Redis:
+//
+//	b := breaker.New("redis", breaker.Options{})
+//	err := b.Do(func() error {
+//		return redis.SetContext(ctx, key, value, 60)
+//	})
+//
+// Calls which fail with context.Canceled were given up by the caller and
+// count neither as success nor as failure.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
+	"github.com/zhlls/go-common/metrics"
+)
+
+const (
+	defaultWindow           = 10 * time.Second
+	defaultBuckets          = 10
+	defaultMinRequests      = 20
+	defaultFailureRate      = 0.5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultHalfOpenRequests = 5
+)
+
+// ErrOpen is returned instead of calling through an open breaker.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// StateClosed lets all calls through.
+	StateClosed State = iota
+	// StateOpen fails all calls fast.
+	StateOpen
+	// StateHalfOpen lets a limited number of trial calls through.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Options configures a Breaker. Zero values take the defaults.
+type Options struct {
+	// Window is the rolling window over which calls are counted, 10s by
+	// default.
+	Window time.Duration
+
+	// Buckets is the number of buckets the window is divided in, 10 by
+	// default.
+	Buckets int
+
+	// MinRequests is the number of calls within the window below which the
+	// breaker does not open, 20 by default.
+	MinRequests int
+
+	// FailureRate opens the breaker when this fraction of calls within the
+	// window failed, 0.5 by default.
+	FailureRate float64
+
+	// SlowCallDuration is the duration from which a call counts as slow,
+	// zero disables slow call tracking.
+	SlowCallDuration time.Duration
+
+	// SlowCallRate opens the breaker when this fraction of calls within the
+	// window was slow, 1 by default when SlowCallDuration is set.
+	SlowCallRate float64
+
+	// OpenTimeout is how long the breaker stays open before letting trial
+	// calls through, 30s by default.
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of trial calls which have to succeed
+	// for the breaker to close, 5 by default.
+	HalfOpenRequests int
+
+	// IsFailure decides whether the error of a call counts as failure, any
+	// non-nil error by default.
+	IsFailure func(err error) bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Window <= 0 {
+		o.Window = defaultWindow
+	}
+	if o.Buckets <= 0 {
+		o.Buckets = defaultBuckets
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = defaultMinRequests
+	}
+	if o.FailureRate <= 0 {
+		o.FailureRate = defaultFailureRate
+	}
+	if o.SlowCallDuration > 0 && o.SlowCallRate <= 0 {
+		o.SlowCallRate = 1
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = defaultOpenTimeout
+	}
+	if o.HalfOpenRequests <= 0 {
+		o.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	if o.IsFailure == nil {
+		o.IsFailure = func(err error) bool { return err != nil }
+	}
+	return o
+}
+
+// Breaker is a circuit breaker, safe for concurrent use.
+type Breaker struct {
+	name string
+	opts Options
+
+	mu       sync.Mutex
+	state    State
+	window   *window
+	openedAt time.Time
+	// generation changes with every state change, so calls which started
+	// in an earlier state are not counted
+	generation uint64
+	trials     int
+	successes  int
+}
+
+// New creates a closed breaker. name identifies it in logs and metrics.
+func New(name string, opts Options) *Breaker {
+	opts = opts.withDefaults()
+	b := &Breaker{
+		name:   name,
+		opts:   opts,
+		window: newWindow(opts.Window, opts.Buckets),
+	}
+	metrics.CollectCircuitBreakerState(name, float64(StateClosed))
+	return b
+}
+
+// Name returns the name of the breaker.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkOpenTimeout(time.Now())
+	return b.state
+}
+
+// Do calls fn unless the breaker is open, and records its outcome.
+func (b *Breaker) Do(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err)
+	return err
+}
+
+// Allow checks whether a call may proceed. If so the returned function
+// must be called with the outcome of the call, otherwise the error wraps
+// ErrOpen. An outcome of context.Canceled is not counted and frees the
+// half-open trial for another call.
+func (b *Breaker) Allow() (func(err error), error) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.checkOpenTimeout(now)
+	switch b.state {
+	case StateOpen:
+		return nil, &OpenError{Name: b.name}
+	case StateHalfOpen:
+		if b.trials >= b.opts.HalfOpenRequests {
+			return nil, &OpenError{Name: b.name}
+		}
+		b.trials++
+	}
+
+	generation := b.generation
+	return func(err error) {
+		if err != nil && errors.Is(err, context.Canceled) {
+			b.cancel(generation)
+			return
+		}
+		b.record(generation, b.opts.IsFailure(err), time.Since(now))
+	}, nil
+}
+
+// cancel gives back the half-open trial of a call canceled by the caller.
+func (b *Breaker) cancel(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == StateHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+func (b *Breaker) record(generation uint64, failed bool, elapsed time.Duration) {
+	now := time.Now()
+	slow := b.opts.SlowCallDuration > 0 && elapsed >= b.opts.SlowCallDuration
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case StateClosed:
+		b.window.add(now, failed, slow)
+		total, failures, slows := b.window.sum(now)
+		if total < b.opts.MinRequests {
+			return
+		}
+		if float64(failures) >= b.opts.FailureRate*float64(total) ||
+			(b.opts.SlowCallRate > 0 && float64(slows) >= b.opts.SlowCallRate*float64(total)) {
+			b.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failed || (b.opts.SlowCallRate > 0 && slow) {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.opts.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	}
+}
+
+func (b *Breaker) checkOpenTimeout(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.opts.OpenTimeout {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.trials, b.successes = 0, 0
+	b.window.reset()
+	if state == StateOpen {
+		b.openedAt = now
+	}
+
+	fields := []zap.Field{
+		zap.String("name", b.name),
+		zap.String("from", from.String()),
+		zap.String("to", state.String()),
+	}
+	if state == StateOpen {
+		log.Warn("circuit breaker state changed", fields...)
+	} else {
+		log.Info("circuit breaker state changed", fields...)
+	}
+	metrics.CollectCircuitBreakerState(b.name, float64(state))
+}
+
+// OpenError is returned by an open breaker, it matches ErrOpen with
+// errors.Is.
+type OpenError struct {
+	Name string
+}
+
+func (e *OpenError) Error() string {
+	return e.Name + ": " + ErrOpen.Error()
+}
+
+// Is makes errors.Is(err, ErrOpen) hold.
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// Group keeps one breaker per name, e.g. per host, created on first use.
+type Group struct {
+	opts Options
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewGroup creates a Group whose breakers are configured by opts.
+func NewGroup(opts Options) *Group {
+	return &Group{opts: opts, breakers: map[string]*Breaker{}}
+}
+
+// Get returns the breaker of name.
+func (g *Group) Get(name string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[name]
+	if !ok {
+		b = New(name, g.opts)
+		g.breakers[name] = b
+	}
+	return b
+}
@@ -0,0 +1,36 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHalfOpenCanceledTrialIsNeutral(t *testing.T) {
+	b := New("test", Options{MinRequests: 1, HalfOpenRequests: 1, OpenTimeout: 10 * time.Millisecond})
+	_ = b.Do(func() error { return errors.New("down") })
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("state %v, want open", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("half-open trial not allowed: %v", err)
+	}
+	done(context.Canceled)
+	if got := b.State(); got != StateHalfOpen {
+		t.Fatalf("state %v after canceled trial, want half-open", got)
+	}
+
+	// the canceled trial gave its slot back
+	done, err = b.Allow()
+	if err != nil {
+		t.Fatalf("second trial not allowed: %v", err)
+	}
+	done(nil)
+	if got := b.State(); got != StateClosed {
+		t.Errorf("state %v after successful trial, want closed", got)
+	}
+}
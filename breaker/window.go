@@ -0,0 +1,58 @@
+package breaker
+
+import "time"
+
+type bucket struct {
+	start    time.Time
+	total    int
+	failures int
+	slow     int
+}
+
+// window counts calls in buckets covering a rolling time window.
+type window struct {
+	width   time.Duration
+	buckets []bucket
+}
+
+func newWindow(size time.Duration, buckets int) *window {
+	width := size / time.Duration(buckets)
+	if width <= 0 {
+		width = size
+	}
+	return &window{width: width, buckets: make([]bucket, buckets)}
+}
+
+func (w *window) add(now time.Time, failed, slow bool) {
+	start := now.Truncate(w.width)
+	b := &w.buckets[int(start.UnixNano()/int64(w.width))%len(w.buckets)]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	b.total++
+	if failed {
+		b.failures++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+func (w *window) sum(now time.Time) (total, failures, slow int) {
+	oldest := now.Truncate(w.width).Add(-w.width * time.Duration(len(w.buckets)-1))
+	for _, b := range w.buckets {
+		if b.start.Before(oldest) {
+			continue
+		}
+		total += b.total
+		failures += b.failures
+		slow += b.slow
+	}
+	return total, failures, slow
+}
+
+func (w *window) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = bucket{}
+	}
+}
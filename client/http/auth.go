@@ -0,0 +1,171 @@
+package http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names set by HMACSigner.
+const (
+	HeaderTimestamp     = "X-Timestamp"
+	HeaderContentSHA256 = "X-Content-Sha256"
+)
+
+// Signer authenticates outbound requests, e.g. by setting the Authorization
+// header. It is called for every attempt of a request, with a copy the
+// signer may modify.
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// SignerFunc adapts a function to a Signer.
+type SignerFunc func(req *http.Request) error
+
+// Sign calls f.
+func (f SignerFunc) Sign(req *http.Request) error {
+	return f(req)
+}
+
+// Bearer sets a static bearer token.
+func Bearer(token string) Signer {
+	value := "Bearer " + token
+	return SignerFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", value)
+		return nil
+	})
+}
+
+// BasicAuth sets HTTP basic auth credentials.
+func BasicAuth(username, password string) Signer {
+	return SignerFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// HMACOptions configures an HMACSigner.
+type HMACOptions struct {
+	// KeyID tells the server which secret signed the request.
+	KeyID string
+
+	// Secret is the shared secret.
+	Secret []byte
+
+	// Hash is the hash of the HMAC, SHA-256 by default.
+	Hash func() hash.Hash
+
+	// Algorithm names Hash in the Authorization header, "HMAC-SHA256" by
+	// default.
+	Algorithm string
+}
+
+// HMACSigner signs requests with a shared secret. It sets HeaderTimestamp
+// to the unix time, HeaderContentSHA256 to the hex SHA-256 of the body and
+//
+//	Authorization: HMAC-SHA256 KeyId=<key id>,Signature=<base64 HMAC>
+//
+// where the HMAC is taken over StringToSign.
+type HMACSigner struct {
+	opts HMACOptions
+	now  func() time.Time
+}
+
+// NewHMACSigner creates an HMACSigner as configured by opts.
+func NewHMACSigner(opts HMACOptions) *HMACSigner {
+	if opts.Hash == nil {
+		opts.Hash = sha256.New
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = "HMAC-SHA256"
+	}
+	return &HMACSigner{opts: opts, now: time.Now}
+}
+
+// Sign signs req.
+func (s *HMACSigner) Sign(req *http.Request) error {
+	body, err := peekBody(req)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	bodyHash := hex.EncodeToString(sum[:])
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+
+	mac := hmac.New(s.opts.Hash, s.opts.Secret)
+	_, _ = io.WriteString(mac, StringToSign(req.Method, req.URL.RequestURI(), timestamp, bodyHash))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderContentSHA256, bodyHash)
+	req.Header.Set("Authorization", s.opts.Algorithm+" KeyId="+s.opts.KeyID+",Signature="+signature)
+	return nil
+}
+
+// StringToSign returns the string HMACSigner signs, so servers can verify
+// signatures: the method, request URI, timestamp and hex body hash joined
+// by newlines.
+func StringToSign(method, requestURI, timestamp, bodyHash string) string {
+	return strings.Join([]string{method, requestURI, timestamp, bodyHash}, "\n")
+}
+
+// peekBody returns the body of req, leaving it readable again.
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return data, nil
+}
+
+// invalidator is implemented by signers caching credentials, which are
+// dropped when the server answers 401.
+type invalidator interface {
+	Invalidate()
+}
+
+// signerTransport signs every attempt of a request.
+type signerTransport struct {
+	next   http.RoundTripper
+	signer Signer
+}
+
+func (t *signerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request of the caller
+	req = req.Clone(req.Context())
+	if err := t.signer.Sign(req); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		if inv, ok := t.signer.(invalidator); ok {
+			inv.Invalidate()
+		}
+	}
+	return resp, err
+}
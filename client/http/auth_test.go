@@ -0,0 +1,64 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// onlyReader hides the type of its reader, so http.NewRequest sets no
+// GetBody.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestHMACSigner(t *testing.T) {
+	const body = `{"name":"alice"}`
+	now := time.Unix(1700000000, 0)
+	s := NewHMACSigner(HMACOptions{KeyID: "key-1", Secret: []byte("secret")})
+	s.now = func() time.Time { return now }
+
+	for name, r := range map[string]io.Reader{
+		"rewindable": strings.NewReader(body),
+		"stream":     onlyReader{strings.NewReader(body)},
+	} {
+		req, err := http.NewRequest(http.MethodPost, "http://api/users?page=2", r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Sign(req); err != nil {
+			t.Fatal(err)
+		}
+
+		sum := sha256.Sum256([]byte(body))
+		bodyHash := hex.EncodeToString(sum[:])
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		_, _ = io.WriteString(mac, StringToSign(http.MethodPost, "/users?page=2", timestamp, bodyHash))
+		want := "HMAC-SHA256 KeyId=key-1,Signature=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s: Authorization %q, want %q", name, got, want)
+		}
+		if got := req.Header.Get(HeaderTimestamp); got != timestamp {
+			t.Errorf("%s: timestamp %q, want %q", name, got, timestamp)
+		}
+		if got := req.Header.Get(HeaderContentSHA256); got != bodyHash {
+			t.Errorf("%s: body hash %q, want %q", name, got, bodyHash)
+		}
+		if data, _ := ioutil.ReadAll(req.Body); string(data) != body {
+			t.Errorf("%s: body after signing %q, want %q", name, data, body)
+		}
+		if req.GetBody == nil {
+			t.Errorf("%s: body cannot be rewound for retries", name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/zhlls/go-common/balancer"
+)
+
+// balancerTransport sends requests to a logical service name to an endpoint
+// picked by the balancer of the service. Transport errors and 5xx responses
+// count as failures of the endpoint.
+type balancerTransport struct {
+	next     http.RoundTripper
+	services map[string]*balancer.Balancer
+}
+
+func (t *balancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, ok := t.services[req.URL.Host]
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	// the attempts of a hedged request go to different endpoints
+	picked, _ := req.Context().Value(pickedKey).(*pickedEndpoints)
+	addr, done, err := b.Pick(picked.list()...)
+	if err != nil {
+		return nil, err
+	}
+	picked.add(addr)
+
+	// a RoundTripper must not modify the request of the caller
+	service := req.URL.Host
+	req = req.Clone(context.WithValue(req.Context(), serviceKey, service))
+	req.URL.Host = addr
+	if req.Host == "" {
+		req.Host = service
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	done(outcome(req.Method, req.URL.Redacted(), statusOf(resp), err))
+	return resp, err
+}
+
+// pickedEndpoints collects the endpoints picked by the attempts of a request.
+// A nil *pickedEndpoints is empty and ignores additions.
+type pickedEndpoints struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (p *pickedEndpoints) list() []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.addrs...)
+}
+
+func (p *pickedEndpoints) add(addr string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.addrs = append(p.addrs, addr)
+	p.mu.Unlock()
+}
+
+// serviceTransport sends requests to the endpoints of balanced services over
+// a transport per service, which verifies TLS certificates against the
+// service name instead of the endpoint address in the URL.
+type serviceTransport struct {
+	next     *http.Transport
+	services map[string]*http.Transport
+}
+
+func newServiceTransport(next *http.Transport, services map[string]*balancer.Balancer) *serviceTransport {
+	t := &serviceTransport{next: next, services: make(map[string]*http.Transport, len(services))}
+	for service := range services {
+		st := next.Clone()
+		st.TLSClientConfig = serviceTLSConfig(next.TLSClientConfig, service)
+		t.services[service] = st
+	}
+	return t
+}
+
+func (t *serviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if service, ok := req.Context().Value(serviceKey).(string); ok {
+		if st, ok := t.services[service]; ok {
+			return st.RoundTrip(req)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+func (t *serviceTransport) CloseIdleConnections() {
+	t.next.CloseIdleConnections()
+	for _, st := range t.services {
+		st.CloseIdleConnections()
+	}
+}
+
+// serviceTLSConfig returns a copy of cfg whose ServerName is the host of
+// service, unless cfg sets one.
+func serviceTLSConfig(cfg *tls.Config, service string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = service
+		if host, _, err := net.SplitHostPort(service); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,170 @@
+package http_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhlls/go-common/balancer"
+	httpclient "github.com/zhlls/go-common/client/http"
+)
+
+// serviceCert returns a certificate valid for name only, not for the
+// address of the test server.
+func serviceCert(t *testing.T, name string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// hostRecorder counts the requests of a test server by Host header.
+type hostRecorder struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (r *hostRecorder) handler(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.hosts = append(r.hosts, req.Host)
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}
+}
+
+func (r *hostRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.hosts)
+}
+
+func newServiceBalancer(t *testing.T, opts balancer.Options, addrs ...string) *balancer.Balancer {
+	b, err := balancer.New("user-service", balancer.Static(addrs...), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+func TestBalancedRequestsKeepServiceHost(t *testing.T) {
+	var a, b hostRecorder
+	srvA := httptest.NewServer(a.handler(http.StatusOK))
+	defer srvA.Close()
+	srvB := httptest.NewServer(b.handler(http.StatusOK))
+	defer srvB.Close()
+
+	bal := newServiceBalancer(t, balancer.Options{},
+		srvA.Listener.Addr().String(), srvB.Listener.Addr().String())
+	c, err := httpclient.NewClient(httpclient.Options{
+		Services: map[string]*balancer.Balancer{"user-service": bal},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := c.Get(context.Background(), "http://user-service/users/1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.count() != 2 || b.count() != 2 {
+		t.Errorf("requests %d and %d, want 2 per endpoint", a.count(), b.count())
+	}
+	for _, host := range append(a.hosts, b.hosts...) {
+		if host != "user-service" {
+			t.Errorf("Host header %q, want the service name", host)
+		}
+	}
+}
+
+func TestBalancedRequestsEjectFailingEndpoint(t *testing.T) {
+	var ok, failing hostRecorder
+	srvOK := httptest.NewServer(ok.handler(http.StatusOK))
+	defer srvOK.Close()
+	srvFailing := httptest.NewServer(failing.handler(http.StatusInternalServerError))
+	defer srvFailing.Close()
+
+	bal := newServiceBalancer(t, balancer.Options{ConsecutiveFailures: 1, EjectionTime: time.Minute},
+		srvFailing.Listener.Addr().String(), srvOK.Listener.Addr().String())
+	c, err := httpclient.NewClient(httpclient.Options{
+		Services: map[string]*balancer.Balancer{"user-service": bal},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		_, _ = c.Get(context.Background(), "http://user-service/")
+	}
+	if failing.count() != 1 {
+		t.Errorf("failing endpoint got %d requests, want 1 before its ejection", failing.count())
+	}
+}
+
+func TestBalancedRequestsVerifyServiceName(t *testing.T) {
+	cert, pool := serviceCert(t, "user-service")
+	var rec hostRecorder
+	srv := httptest.NewUnstartedServer(rec.handler(http.StatusOK))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	opts := httpclient.Options{
+		TLSConfig: &tls.Config{RootCAs: pool},
+		Services: map[string]*balancer.Balancer{
+			"user-service": newServiceBalancer(t, balancer.Options{}, srv.Listener.Addr().String()),
+		},
+	}
+	c, err := httpclient.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := httpclient.NewFastClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, r := range map[string]httpclient.Requester{"Client": c, "FastClient": fc} {
+		if _, err := r.Get(context.Background(), "https://user-service/"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// requests to the endpoint itself are still verified against its address
+	_, err = c.Get(context.Background(), "https://"+srv.Listener.Addr().String()+"/")
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("unbalanced request: %v, want a certificate error", err)
+	}
+}
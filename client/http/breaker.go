@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/zhlls/go-common/breaker"
+)
+
+// HostKey is the default Options.BreakerKey, one breaker per host.
+func HostKey(req *http.Request) string {
+	return req.URL.Host
+}
+
+// breakerTransport fails requests fast while the breaker of their host is
+// open. Transport errors and 5xx responses count as failures.
+type breakerTransport struct {
+	next  http.RoundTripper
+	group *breaker.Group
+	key   func(req *http.Request) string
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.group.Get(t.key(req)).Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	done(breakerOutcome(outcome(req.Method, req.URL.Redacted(), statusOf(resp), err), err))
+	return resp, err
+}
+
+// breakerOutcome passes requests given up by the caller on to breakers as
+// context.Canceled, which they count neither as success nor as failure.
+func breakerOutcome(result, err error) error {
+	if err != nil && errors.Is(err, context.Canceled) {
+		return err
+	}
+	return result
+}
+
+// outcome returns the error counted by breakers and balancers for a
+// request, nil unless it failed with a transport error or a 5xx status.
+func outcome(method, url string, status int, err error) error {
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		// given up by the caller, says nothing about the downstream
+		return nil
+	case err != nil:
+		return err
+	case status >= http.StatusInternalServerError:
+		return &StatusError{Method: method, URL: url, StatusCode: status}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
+)
+
+const defaultCertCheckInterval = 10 * time.Second
+
+// CertReloader provides a TLS client certificate for mutual TLS, reloaded
+// from disk when the certificate or key file changed, e.g. when they are
+// rotated by cert-manager:
+//
+//	certs, err := http.NewCertReloader(certFile, keyFile)
+//	c, err := http.NewClient(http.Options{TLSConfig: certs.TLSConfig(nil)})
+//
+// New connections use the new certificate, pooled ones keep the old one.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	now      func() time.Time
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertReloader loads the PEM encoded certificate and key files.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a clone of base, or a new config when nil, presenting
+// the client certificate.
+func (r *CertReloader) TLSConfig(base *tls.Config) *tls.Config {
+	var cfg *tls.Config
+	if base != nil {
+		cfg = base.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	cfg.GetClientCertificate = r.GetClientCertificate
+	return cfg
+}
+
+// GetClientCertificate conforms to tls.Config.GetClientCertificate. The
+// files are checked for changes at most every 10s, a certificate which
+// fails to load is logged and the previous one kept.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checkedAt) >= defaultCertCheckInterval {
+		r.checkedAt = now
+		modTime, err := r.filesModTime()
+		if err == nil && !modTime.Equal(r.modTime) {
+			err = r.load(modTime)
+		}
+		if err != nil {
+			log.Warn("reload client certificate failed",
+				zap.String("cert", r.certFile),
+				zap.Error(err))
+		}
+	}
+	return r.cert, nil
+}
+
+// filesModTime returns the later modification time of the two files.
+func (r *CertReloader) filesModTime() (time.Time, error) {
+	cert, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	key, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if key.ModTime().After(cert.ModTime()) {
+		return key.ModTime(), nil
+	}
+	return cert.ModTime(), nil
+}
+
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	log.Info("client certificate loaded", zap.String("cert", r.certFile))
+	return nil
+}
@@ -0,0 +1,374 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhlls/go-common/balancer"
+	"github.com/zhlls/go-common/breaker"
+)
+
+const (
+	defaultTimeout               = 30 * time.Second
+	defaultDialTimeout           = 5 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 5 * time.Second
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultExpectContinueTimeout = time.Second
+	defaultMaxIdleConns          = 100
+	defaultMaxIdleConnsPerHost   = 10
+	defaultMaxErrorBodySize      = 512
+	defaultMaxResponseSize       = 10 << 20
+)
+
+// Options configures a Client. Zero values take the defaults.
+type Options struct {
+	// BaseURL is prepended to relative request URLs, e.g.
+	// "http://user-service:8080/rest".
+	BaseURL string
+
+	// Timeout limits a whole request including reading the response body,
+	// 30s by default. Negative disables it, leaving the context deadline.
+	Timeout time.Duration
+
+	// DialTimeout limits establishing a connection, 5s by default.
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout limits the TLS handshake, 5s by default.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout limits waiting for the response headers after
+	// the request was written, unlimited by default.
+	ResponseHeaderTimeout time.Duration
+
+	// IdleConnTimeout closes pooled connections idle for longer, 90s by
+	// default.
+	IdleConnTimeout time.Duration
+
+	// MaxIdleConns limits the pooled idle connections of all hosts, 100 by
+	// default.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost limits the pooled idle connections per host, 10
+	// by default.
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost limits the connections per host, unlimited by default.
+	MaxConnsPerHost int
+
+	// Header is sent with every request unless the request sets it itself.
+	Header http.Header
+
+	// TLSConfig configures TLS connections, e.g. private CAs.
+	TLSConfig *tls.Config
+
+	// MaxErrorBodySize is the size of the response body snippet kept in a
+	// StatusError, 512 bytes by default.
+	MaxErrorBodySize int
+
+	// MaxResponseSize limits the response bodies read by the JSON helpers,
+	// 10MB by default. Negative disables the limit.
+	MaxResponseSize int64
+
+	// Transport replaces the pooled transport built from the options above.
+	// Requests still go through the tracing transport.
+	Transport http.RoundTripper
+
+	// Retry retries failed requests, nil disables retries.
+	Retry *RetryPolicy
+
+	// Signer authenticates every attempt of a request, e.g. Bearer,
+	// ClientCredentials or HMACSigner. For mutual TLS see CertReloader.
+	Signer Signer
+
+	// Hedge sends hedged requests, nil disables hedging.
+	Hedge *HedgePolicy
+
+	// Breaker enables a circuit breaker per BreakerKey, nil disables it.
+	// Requests to an open breaker fail with an error matching
+	// breaker.ErrOpen.
+	Breaker *breaker.Options
+
+	// BreakerKey names the breaker of a request, HostKey by default.
+	BreakerKey func(req *http.Request) string
+
+	// Services balances requests whose URL host is a key, a logical service
+	// name, over the endpoints of its Balancer. Every attempt picks an
+	// endpoint, breakers and metrics are per endpoint, the Host header
+	// and the TLS server name keep the service name. A Transport which is
+	// not an *http.Transport must verify certificates against the service
+	// name itself.
+	Services map[string]*balancer.Balancer
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.TLSHandshakeTimeout == 0 {
+		o.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if o.IdleConnTimeout == 0 {
+		o.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = defaultMaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost == 0 {
+		o.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if o.MaxErrorBodySize == 0 {
+		o.MaxErrorBodySize = defaultMaxErrorBodySize
+	}
+	if o.MaxResponseSize == 0 {
+		o.MaxResponseSize = defaultMaxResponseSize
+	}
+	return o
+}
+
+// Requester is implemented by Client and FastClient, so callers can switch
+// between net/http and fasthttp without changes.
+type Requester interface {
+	// Send sends a request for uri and returns the response body.
+	Send(ctx context.Context, method, uri string, body io.Reader) ([]byte, error)
+	// Get sends a GET request for uri and returns the response body.
+	Get(ctx context.Context, uri string) ([]byte, error)
+	// Post sends body as POST request to uri and returns the response body.
+	Post(ctx context.Context, uri, contentType string, body io.Reader) ([]byte, error)
+	// DoJSON sends in as JSON and decodes the JSON response into out.
+	DoJSON(ctx context.Context, method, uri string, in, out interface{}) error
+	// GetJSON sends a GET request for uri and decodes the JSON response
+	// into out.
+	GetJSON(ctx context.Context, uri string, out interface{}) error
+	// PostJSON posts in as JSON to uri and decodes the JSON response into
+	// out.
+	PostJSON(ctx context.Context, uri string, in, out interface{}) error
+	// CloseIdleConnections closes the pooled connections which are idle.
+	CloseIdleConnections()
+}
+
+var _ Requester = (*Client)(nil)
+
+// Client is an HTTP client with connection pooling, default headers,
+// tracing and metrics of every request. Responses with a non-2xx status are
+// returned as *StatusError.
+type Client struct {
+	opts Options
+	base *url.URL
+	http *http.Client
+}
+
+// NewClient creates a Client as configured by opts.
+func NewClient(opts Options) (*Client, error) {
+	opts = opts.withDefaults()
+
+	base, err := parseBaseURL(opts.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{opts: opts, base: base}
+
+	rt := opts.Transport
+	if rt == nil {
+		rt = newTransport(opts)
+	}
+	if t, ok := rt.(*http.Transport); ok && len(opts.Services) > 0 {
+		rt = newServiceTransport(t, opts.Services)
+	}
+	timeout := opts.Timeout
+	if timeout < 0 {
+		timeout = 0
+	}
+	rt = &transport{&metricsTransport{rt}}
+	if opts.Breaker != nil {
+		key := opts.BreakerKey
+		if key == nil {
+			key = HostKey
+		}
+		rt = &breakerTransport{next: rt, group: breaker.NewGroup(*opts.Breaker), key: key}
+	}
+	if len(opts.Services) > 0 {
+		rt = &balancerTransport{next: rt, services: opts.Services}
+	}
+	if opts.Signer != nil {
+		rt = &signerTransport{next: rt, signer: opts.Signer}
+	}
+	if opts.Hedge != nil {
+		rt = &hedgeTransport{next: rt, policy: opts.Hedge.withDefaults()}
+	}
+	if opts.Retry != nil {
+		rt = &retryTransport{next: rt, policy: opts.Retry.withDefaults()}
+	}
+	c.http = &http.Client{
+		Transport: rt,
+		Timeout:   timeout,
+	}
+	return c, nil
+}
+
+func newTransport(opts Options) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: defaultKeepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       opts.TLSConfig,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+	}
+}
+
+// NewRequest creates a request for uri, which is resolved against the base
+// URL when relative.
+func (c *Client) NewRequest(ctx context.Context, method, uri string, body io.Reader) (*http.Request, error) {
+	u, err := c.resolve(uri)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, method, u, body)
+}
+
+func (c *Client) resolve(uri string) (string, error) {
+	return resolveURL(c.base, uri)
+}
+
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	if baseURL == "" {
+		return nil, nil
+	}
+	return url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+}
+
+func resolveURL(base *url.URL, uri string) (string, error) {
+	if base == nil {
+		return uri, nil
+	}
+	ref, err := url.Parse(strings.TrimPrefix(uri, "/"))
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Do sends req with the default headers. A response with a non-2xx status
+// is closed and returned as *StatusError, otherwise the caller must close
+// the response body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	return c.do(req, c.opts.MaxErrorBodySize)
+}
+
+// do is Do keeping up to maxErrorBody bytes of the body of a non-2xx
+// response in the *StatusError.
+func (c *Client) do(req *http.Request, maxErrorBody int) (*http.Response, error) {
+	c.setDefaultHeaders(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, newStatusError(req, resp, maxErrorBody)
+	}
+	return resp, nil
+}
+
+// setDefaultHeaders sets the default headers req does not set itself.
+func (c *Client) setDefaultHeaders(req *http.Request) {
+	for k, vs := range c.opts.Header {
+		if _, ok := req.Header[k]; !ok {
+			// appending to the header of one request must not reach others
+			req.Header[k] = append([]string(nil), vs...)
+		}
+	}
+}
+
+// Send sends a request for uri and returns the response body.
+func (c *Client) Send(ctx context.Context, method, uri string, body io.Reader) ([]byte, error) {
+	req, err := c.NewRequest(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Get sends a GET request for uri and returns the response body.
+func (c *Client) Get(ctx context.Context, uri string) ([]byte, error) {
+	return c.Send(ctx, http.MethodGet, uri, nil)
+}
+
+// Post sends body as POST request to uri and returns the response body.
+func (c *Client) Post(ctx context.Context, uri, contentType string, body io.Reader) ([]byte, error) {
+	req, err := c.NewRequest(ctx, http.MethodPost, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// CloseIdleConnections closes the pooled connections which are idle.
+func (c *Client) CloseIdleConnections() {
+	c.http.CloseIdleConnections()
+}
+
+// closeHookBody calls done once when the body is read to its end or closed.
+type closeHookBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *closeHookBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
+
+func (b *closeHookBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// onBodyClose calls done when the body of resp is read to its end or
+// closed, at once when resp has no body.
+func onBodyClose(resp *http.Response, done func()) {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		done()
+		return
+	}
+	resp.Body = &closeHookBody{ReadCloser: resp.Body, done: done}
+}
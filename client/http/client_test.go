@@ -0,0 +1,123 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/zhlls/go-common/client/http"
+)
+
+func TestClientDefaultHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	team := make([]string, 1, 4)
+	team[0] = "core"
+	c, err := httpclient.NewClient(httpclient.Options{
+		BaseURL: srv.URL + "/rest",
+		Header:  http.Header{"X-Team": team, "User-Agent": {"go-common"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := c.NewRequest(context.Background(), http.MethodGet, "/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "caller")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got.Get("X-Team") != "core" || got.Get("User-Agent") != "caller" {
+		t.Errorf("headers %v, want the default X-Team and the caller's User-Agent", got)
+	}
+
+	// the header of the request does not share the default slices
+	req.Header.Add("X-Team", "extra")
+	if extra := team[:2][1]; extra != "" {
+		t.Errorf("appending to a request header wrote %q into the default header", extra)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c, err := httpclient.NewClient(httpclient.Options{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ne net.Error
+	if _, err := c.Get(context.Background(), srv.URL); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("error %v, want a timeout", err)
+	}
+
+	// without a Timeout the context deadline still applies
+	c, err = httpclient.NewClient(httpclient.Options{Timeout: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, srv.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestClientStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reason", "gone")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	c, err := httpclient.NewClient(httpclient.Options{MaxErrorBodySize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(context.Background(), "http://user:secret@"+strings.TrimPrefix(srv.URL, "http://")+"/users/1")
+	var se *httpclient.StatusError
+	if !errors.As(err, &se) {
+		t.Fatalf("error %v, want *StatusError", err)
+	}
+	if se.StatusCode != http.StatusNotFound || se.Method != http.MethodGet ||
+		se.Header.Get("X-Reason") != "gone" || string(se.Body) != strings.Repeat("x", 10) {
+		t.Errorf("status error %+v", se)
+	}
+	if strings.Contains(se.URL, "secret") || strings.Contains(err.Error(), "secret") {
+		t.Errorf("password in error %q", err)
+	}
+}
+
+func TestSimpleTraceDoKeepsContract(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("failed"))
+	}))
+	defer srv.Close()
+
+	data, err := httpclient.SimpleTraceGet(context.Background(), srv.URL)
+	if err != nil || string(data) != "failed" {
+		t.Errorf("SimpleTraceGet: %q, %v, want the body of the 500 and no error", data, err)
+	}
+}
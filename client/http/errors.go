@@ -0,0 +1,50 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// StatusError is returned for responses with a non-2xx status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	// Body is the start of the response body, up to Options.MaxErrorBodySize.
+	Body []byte
+}
+
+func newStatusError(req *http.Request, resp *http.Response, maxBody int) *StatusError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, int64(maxBody)))
+	// drain a little more so the connection can be reused
+	_, _ = io.CopyN(ioutil.Discard, resp.Body, 4<<10)
+	return &StatusError{
+		Method:     req.Method,
+		URL:        req.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if len(e.Body) > 0 {
+		msg += ": " + strings.ToValidUTF8(string(e.Body), "")
+	}
+	return msg
+}
+
+// StatusCode returns the status code of a *StatusError, 0 for other errors.
+func StatusCode(err error) int {
+	var e *StatusError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
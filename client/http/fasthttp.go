@@ -0,0 +1,559 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/breaker"
+	"github.com/zhlls/go-common/log"
+	"github.com/zhlls/go-common/metrics"
+	"github.com/zhlls/go-common/utils"
+)
+
+// HeadersCarrierWriter injects a span context into the headers of a
+// fasthttp request.
+type HeadersCarrierWriter fasthttp.RequestHeader
+
+// Set conforms to the TextMapWriter interface.
+func (c *HeadersCarrierWriter) Set(key, val string) {
+	h := (*fasthttp.RequestHeader)(c)
+	h.Set(key, val)
+}
+
+var _ Requester = (*FastClient)(nil)
+
+// FastClient is the fasthttp counterpart of Client for hot paths, with the
+// same tracing, metrics, retries, circuit breakers and error types.
+//
+// It is configured by the same Options, except that TLSHandshakeTimeout,
+// ResponseHeaderTimeout, MaxIdleConns, MaxIdleConnsPerHost, Transport, Hedge
+// and BreakerKey are ignored, there is one breaker per host. No connection
+// phase metrics are collected and request bodies without Content-Type are
+// sent as application/octet-stream.
+//
+// fasthttp does not watch contexts, a cancelled context makes Do return at
+// once while the request goes on in the background until it completes or
+// reaches its deadline. Requests with a body stream cannot be left behind
+// and are only stopped by the deadline.
+type FastClient struct {
+	opts     Options
+	base     *url.URL
+	client   *fasthttp.Client
+	services map[string]*fasthttp.Client
+	retry    *RetryPolicy
+	breakers *breaker.Group
+}
+
+// NewFastClient creates a FastClient as configured by opts.
+func NewFastClient(opts Options) (*FastClient, error) {
+	opts = opts.withDefaults()
+
+	base, err := parseBaseURL(opts.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	c := &FastClient{
+		opts:   opts,
+		base:   base,
+		client: newFastHTTPClient(opts, opts.TLSConfig),
+	}
+	if len(opts.Services) > 0 {
+		// the endpoint address replaces the service name in the URI, keep
+		// the name for TLS
+		c.services = make(map[string]*fasthttp.Client, len(opts.Services))
+		for service := range opts.Services {
+			c.services[service] = newFastHTTPClient(opts, serviceTLSConfig(opts.TLSConfig, service))
+		}
+	}
+	if opts.Retry != nil {
+		policy := opts.Retry.withDefaults()
+		c.retry = &policy
+	}
+	if opts.Breaker != nil {
+		c.breakers = breaker.NewGroup(*opts.Breaker)
+	}
+	return c, nil
+}
+
+func newFastHTTPClient(opts Options, tlsConfig *tls.Config) *fasthttp.Client {
+	dialTimeout := opts.DialTimeout
+	return &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return fasthttp.DialTimeout(addr, dialTimeout)
+		},
+		TLSConfig:           tlsConfig,
+		MaxConnsPerHost:     opts.MaxConnsPerHost,
+		MaxIdleConnDuration: opts.IdleConnTimeout,
+	}
+}
+
+// Do sends req with the default headers and reads the response into resp.
+// A response with a non-2xx status is returned as *StatusError. The caller
+// owns req and resp, as with fasthttp.Client.
+func (c *FastClient) Do(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	return c.do(ctx, req, resp, c.opts.MaxErrorBodySize)
+}
+
+func (c *FastClient) do(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, maxErrorBody int) error {
+	for k, vs := range c.opts.Header {
+		if len(req.Header.Peek(k)) == 0 {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+
+	deadline := c.deadline(ctx)
+	var err error
+	if c.retryable(ctx, req) {
+		err = c.doRetry(ctx, req, resp, deadline)
+	} else {
+		err = c.attempt(ctx, req, resp, deadline)
+	}
+	if err != nil {
+		return err
+	}
+	if code := resp.StatusCode(); code < 200 || code > 299 {
+		return newFastStatusError(req, resp, maxErrorBody)
+	}
+	return nil
+}
+
+// deadline returns the earlier of the context deadline and Timeout from
+// now, zero for none.
+func (c *FastClient) deadline(ctx context.Context) time.Time {
+	var deadline time.Time
+	if c.opts.Timeout > 0 {
+		deadline = time.Now().Add(c.opts.Timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	return deadline
+}
+
+func (c *FastClient) retryable(ctx context.Context, req *fasthttp.Request) bool {
+	if c.retry == nil || req.IsBodyStream() {
+		return false
+	}
+	return c.retry.RetryNonIdempotent || idempotent(ctx, string(req.Header.Method()),
+		func(key string) string { return string(req.Header.Peek(key)) })
+}
+
+// doRetry is the retryTransport of FastClient.
+func (c *FastClient) doRetry(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	method := string(req.Header.Method())
+	host := string(req.Host())
+	uri := redactedURI(req)
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "http.client", ext.SpanKindRPCClient)
+	defer span.Finish()
+	ext.HTTPMethod.Set(span, method)
+	ext.HTTPUrl.Set(span, uri)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = c.attempt(context.WithValue(ctx, attemptKey, attempt), req, resp, deadline)
+		status := 0
+		if err == nil {
+			status = resp.StatusCode()
+		}
+		if attempt >= c.retry.MaxAttempts || !c.retry.retryable(status, err) {
+			break
+		}
+
+		delay := c.retry.backoff(attempt)
+		if err == nil {
+			if after, ok := retryAfter(string(resp.Header.Peek("Retry-After"))); ok {
+				if after > c.retry.MaxDelay {
+					break
+				}
+				delay = after
+			}
+		}
+		if !deadline.IsZero() && time.Until(deadline) < delay {
+			break
+		}
+
+		log.Debug("retry http request",
+			zap.String("method", method),
+			zap.String("url", uri),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Int("status", status),
+			zap.Error(err),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		span.SetTag("http.retries", attempt)
+		metrics.CollectHTTPClientRetry(host, method, routeOf(ctx))
+	}
+
+	if err != nil {
+		ext.LogError(span, err)
+		return err
+	}
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode()))
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+	return nil
+}
+
+// attempt sends req once, it is the transport, metricsTransport and
+// breakerTransport of FastClient.
+func (c *FastClient) attempt(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	method := string(req.Header.Method())
+	if c.opts.Signer != nil {
+		if err := c.sign(ctx, req); err != nil {
+			return err
+		}
+	}
+
+	client := c.client
+	var picked func(error)
+	if service := string(req.URI().Host()); c.opts.Services[service] != nil {
+		addr, done, err := c.opts.Services[service].Pick()
+		if err != nil {
+			return err
+		}
+		picked = done
+		client = c.services[service]
+		defer pointTo(req, addr)()
+	}
+	host := string(req.Host())
+
+	var done func(error)
+	if c.breakers != nil {
+		var err error
+		if done, err = c.breakers.Get(host).Allow(); err != nil {
+			if picked != nil {
+				picked(err)
+			}
+			return err
+		}
+	}
+
+	operationName := "http.client"
+	attempt, retried := ctx.Value(attemptKey).(int)
+	if retried {
+		// a child of the span of doRetry
+		operationName = "http.client.attempt"
+	}
+	span, _ := opentracing.StartSpanFromContext(ctx, operationName, ext.SpanKindRPCClient)
+	defer span.Finish()
+	if retried {
+		span.SetTag("http.attempt", attempt)
+	}
+	uri := redactedURI(req)
+	ext.HTTPMethod.Set(span, method)
+	ext.HTTPUrl.Set(span, uri)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	ext.PeerHostname.Set(span, hostname)
+
+	err := opentracing.GlobalTracer().Inject(
+		span.Context(), opentracing.HTTPHeaders,
+		(*HeadersCarrierWriter)(&req.Header))
+	if err != nil {
+		log.Debug("trace inject failed",
+			zap.String("method", method),
+			zap.String("url", uri),
+			zap.Error(err))
+	}
+
+	metrics.CollectHTTPClientInFlight(host, 1)
+	start := time.Now()
+	err = roundTrip(ctx, client, req, resp, deadline)
+	if errors.Is(err, fasthttp.ErrTimeout) {
+		if d, ok := ctx.Deadline(); ok && !d.After(deadline) {
+			err = context.DeadlineExceeded
+		}
+	}
+	if err != nil {
+		// as returned by net/http
+		err = &url.Error{Op: urlErrorOp(method), URL: uri, Err: err}
+	}
+	status := "error"
+	if err == nil {
+		status = statusClass(resp.StatusCode())
+	}
+	metrics.CollectHTTPClientRequest(host, method, routeOf(ctx), status, time.Since(start).Seconds())
+	metrics.CollectHTTPClientInFlight(host, -1)
+
+	if done != nil || picked != nil {
+		status := 0
+		if err == nil {
+			status = resp.StatusCode()
+		}
+		result := outcome(method, uri, status, err)
+		if done != nil {
+			done(breakerOutcome(result, err))
+		}
+		if picked != nil {
+			picked(result)
+		}
+	}
+
+	if err != nil {
+		ext.LogError(span, err)
+		return err
+	}
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode()))
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+	if resp.StatusCode() == http.StatusUnauthorized {
+		if inv, ok := c.opts.Signer.(invalidator); ok {
+			inv.Invalidate()
+		}
+	}
+	return nil
+}
+
+// roundTrip sends req with client, or returns the error of ctx when ctx is
+// done first. The request then goes on with copies of req and resp, which
+// the caller may release.
+func roundTrip(ctx context.Context, client *fasthttp.Client, req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	if ctx.Done() == nil || req.IsBodyStream() {
+		return doDeadline(client, req, resp, deadline)
+	}
+	r := fasthttp.AcquireRequest()
+	req.CopyTo(r)
+	// CopyTo shares a raw body
+	r.SetBody(req.Body())
+	w := fasthttp.AcquireResponse()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- doDeadline(client, r, w, deadline)
+	}()
+
+	select {
+	case err := <-errc:
+		w.CopyTo(resp)
+		fasthttp.ReleaseRequest(r)
+		fasthttp.ReleaseResponse(w)
+		return err
+	case <-ctx.Done():
+		go func() {
+			<-errc
+			fasthttp.ReleaseRequest(r)
+			fasthttp.ReleaseResponse(w)
+		}()
+		return ctx.Err()
+	}
+}
+
+func doDeadline(client *fasthttp.Client, req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	if deadline.IsZero() {
+		return client.Do(req, resp)
+	}
+	return client.DoDeadline(req, resp, deadline)
+}
+
+// sign lets the Signer sign a net/http copy of req and takes over the
+// headers it set.
+func (c *FastClient) sign(ctx context.Context, req *fasthttp.Request) error {
+	hreq, err := http.NewRequestWithContext(ctx, string(req.Header.Method()),
+		string(req.URI().FullURI()), bytes.NewReader(req.Body()))
+	if err != nil {
+		return err
+	}
+	req.Header.VisitAll(func(key, value []byte) {
+		hreq.Header.Add(string(key), string(value))
+	})
+	if err := c.opts.Signer.Sign(hreq); err != nil {
+		return err
+	}
+	for k, vs := range hreq.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	return nil
+}
+
+// pointTo sends req to the endpoint addr of its service, keeping the
+// service name as Host header. The returned function points it back.
+func pointTo(req *fasthttp.Request, addr string) func() {
+	service := string(req.URI().Host())
+	useHostHeader := req.UseHostHeader
+	if len(req.Header.Host()) == 0 {
+		req.Header.SetHost(service)
+	}
+	req.UseHostHeader = true
+	req.URI().SetHost(addr)
+	return func() {
+		req.URI().SetHost(service)
+		req.UseHostHeader = useHostHeader
+	}
+}
+
+// Send sends a request for uri and returns the response body.
+func (c *FastClient) Send(ctx context.Context, method, uri string, body io.Reader) ([]byte, error) {
+	return c.send(ctx, method, uri, "", body)
+}
+
+// Get sends a GET request for uri and returns the response body.
+func (c *FastClient) Get(ctx context.Context, uri string) ([]byte, error) {
+	return c.send(ctx, http.MethodGet, uri, "", nil)
+}
+
+// Post sends body as POST request to uri and returns the response body.
+func (c *FastClient) Post(ctx context.Context, uri, contentType string, body io.Reader) ([]byte, error) {
+	return c.send(ctx, http.MethodPost, uri, contentType, body)
+}
+
+func (c *FastClient) send(ctx context.Context, method, uri, contentType string, body io.Reader) ([]byte, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	if err := c.newRequest(req, method, uri); err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.SetContentType(contentType)
+	}
+	if body != nil {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBodyRaw(data)
+	}
+	if err := c.do(ctx, req, resp, c.opts.MaxErrorBodySize); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), resp.Body()...), nil
+}
+
+// GetJSON sends a GET request for uri and decodes the JSON response into
+// out.
+func (c *FastClient) GetJSON(ctx context.Context, uri string, out interface{}) error {
+	return c.DoJSON(ctx, http.MethodGet, uri, nil, out)
+}
+
+// PostJSON posts in as JSON to uri and decodes the JSON response into out.
+func (c *FastClient) PostJSON(ctx context.Context, uri string, in, out interface{}) error {
+	return c.DoJSON(ctx, http.MethodPost, uri, in, out)
+}
+
+// DoJSON is Client.DoJSON over fasthttp.
+func (c *FastClient) DoJSON(ctx context.Context, method, uri string, in, out interface{}) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	if err := c.newRequest(req, method, uri); err != nil {
+		return err
+	}
+	if in != nil {
+		data, err := utils.JsonMarshal(in)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		req.SetBodyRaw(data)
+		req.Header.SetContentType(contentTypeJSON)
+	}
+	req.Header.Set("Accept", contentTypeJSON)
+
+	if err := c.do(ctx, req, resp, maxEnvelopeSize); err != nil {
+		return decodeEnvelope(err, c.opts.MaxErrorBodySize)
+	}
+	data := resp.Body()
+	if c.opts.MaxResponseSize >= 0 && int64(len(data)) > c.opts.MaxResponseSize {
+		return ErrResponseTooLarge
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := utils.JsonUnmarshal(data, out); err != nil {
+		return fmt.Errorf("decode response of %s %s: %w", method, redactedURI(req), err)
+	}
+	return nil
+}
+
+func (c *FastClient) newRequest(req *fasthttp.Request, method, uri string) error {
+	u, err := resolveURL(c.base, uri)
+	if err != nil {
+		return err
+	}
+	req.SetRequestURI(u)
+	req.Header.SetMethod(method)
+	return nil
+}
+
+// CloseIdleConnections closes the pooled connections which are idle.
+func (c *FastClient) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+	for _, client := range c.services {
+		client.CloseIdleConnections()
+	}
+}
+
+func newFastStatusError(req *fasthttp.Request, resp *fasthttp.Response, maxBody int) *StatusError {
+	header := http.Header{}
+	resp.Header.VisitAll(func(key, value []byte) {
+		header.Add(string(key), string(value))
+	})
+	body := resp.Body()
+	if len(body) > maxBody {
+		body = body[:maxBody]
+	}
+	return &StatusError{
+		Method:     string(req.Header.Method()),
+		URL:        redactedURI(req),
+		StatusCode: resp.StatusCode(),
+		Header:     header,
+		// resp goes back to its pool
+		Body: append([]byte(nil), body...),
+	}
+}
+
+// urlErrorOp returns "Get" for GET and the like.
+func urlErrorOp(method string) string {
+	if method == "" {
+		return "Get"
+	}
+	return method[:1] + strings.ToLower(method[1:])
+}
+
+// redactedURI returns the URI of req with the password replaced by "xxxxx".
+func redactedURI(req *fasthttp.Request) string {
+	uri := string(req.URI().FullURI())
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,280 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zhlls/go-common/metrics"
+)
+
+const (
+	defaultMaxHedges       = 1
+	defaultHedgeRate       = 0.1
+	defaultHedgeMinSamples = 20
+
+	// hedgeBurst caps the hedges saved up by a quiet period
+	hedgeBurst = 10
+	// latencySamples is the number of latencies kept per host for the p95
+	latencySamples = 128
+)
+
+// HedgePolicy configures hedged requests: when a request is not answered
+// after a delay, another attempt is sent, to an endpoint not picked by the
+// earlier attempts when the host is balanced, and the first successful
+// response is taken. Only requests
+// which may be retried, see RetryPolicy, are hedged.
+type HedgePolicy struct {
+	// Delay is the time after which a hedge is sent. When zero it is the
+	// observed p95 latency of the host, requests are not hedged before
+	// MinSamples latencies were observed.
+	Delay time.Duration
+
+	// MaxHedges is the number of hedges per request in addition to the
+	// original, 1 by default.
+	MaxHedges int
+
+	// Rate caps the hedges as fraction of the requests, 0.1 by default.
+	Rate float64
+
+	// MinSamples is the number of latencies observed before the p95 is
+	// used as delay, 20 by default.
+	MinSamples int
+}
+
+func (p HedgePolicy) withDefaults() HedgePolicy {
+	if p.MaxHedges <= 0 {
+		p.MaxHedges = defaultMaxHedges
+	}
+	if p.Rate <= 0 {
+		p.Rate = defaultHedgeRate
+	}
+	if p.MinSamples <= 0 {
+		p.MinSamples = defaultHedgeMinSamples
+	}
+	if p.MinSamples > latencySamples {
+		p.MinSamples = latencySamples
+	}
+	return p
+}
+
+type hedgeTransport struct {
+	next   http.RoundTripper
+	policy HedgePolicy
+
+	mu        sync.Mutex
+	tokens    float64
+	latencies map[string]*latencyWindow
+}
+
+type hedgeResult struct {
+	resp    *http.Response
+	err     error
+	hedge   int
+	elapsed time.Duration
+	cancel  context.CancelFunc
+}
+
+func (r hedgeResult) ok() bool {
+	return r.err == nil && r.resp.StatusCode < http.StatusInternalServerError
+}
+
+func (t *hedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) {
+		return t.next.RoundTrip(req)
+	}
+	host := req.URL.Host
+	delay, ok := t.delay(host)
+	if !ok {
+		start := time.Now()
+		resp, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.observe(host, time.Since(start))
+		}
+		return resp, err
+	}
+	req, err := rewindableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(req.Context(), pickedKey, &pickedEndpoints{})
+	route := routeOf(ctx)
+	results := make(chan hedgeResult, t.policy.MaxHedges+1)
+	var cancels []context.CancelFunc
+	send := func(hedge int) error {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		if hedge > 0 {
+			attemptCtx = context.WithValue(attemptCtx, hedgeKey, hedge)
+		}
+		attemptReq := req.WithContext(attemptCtx)
+		if hedge > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return err
+			}
+			attemptReq.Body = body
+		}
+		cancels = append(cancels, cancel)
+		go func() {
+			start := time.Now()
+			resp, err := t.next.RoundTrip(attemptReq)
+			results <- hedgeResult{resp: resp, err: err, hedge: hedge, elapsed: time.Since(start), cancel: cancel}
+		}()
+		return nil
+	}
+	if err := send(0); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	sent, pending := 1, 1
+	var last hedgeResult
+	for {
+		select {
+		case <-timer.C:
+			if sent > t.policy.MaxHedges || !t.take() {
+				continue
+			}
+			if err := send(sent); err != nil {
+				continue
+			}
+			metrics.CollectHTTPClientHedge(host, req.Method, route)
+			sent++
+			pending++
+			timer.Reset(delay)
+
+		case r := <-results:
+			pending--
+			if r.ok() {
+				t.observe(host, r.elapsed)
+				if r.hedge > 0 {
+					metrics.CollectHTTPClientHedgeWon(host, req.Method, route)
+				}
+				for i, cancel := range cancels {
+					if i != r.hedge {
+						cancel()
+					}
+				}
+				go discard(results, pending)
+				// the attempt is cancelled once its response was read
+				r.resp.Body = &cancelBody{ReadCloser: r.resp.Body, cancel: r.cancel}
+				return r.resp, nil
+			}
+			if last.cancel != nil {
+				closeResult(last)
+			}
+			last = r
+			if pending == 0 {
+				// all attempts failed, the last one is returned
+				if last.err != nil {
+					last.cancel()
+					return nil, last.err
+				}
+				last.resp.Body = &cancelBody{ReadCloser: last.resp.Body, cancel: last.cancel}
+				return last.resp, nil
+			}
+		}
+	}
+}
+
+// take reports whether a hedge is within Rate, spending it.
+func (t *hedgeTransport) take() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// delay returns the hedge delay of host, false when it is unknown. It earns
+// every request its share of hedges.
+func (t *hedgeTransport) delay(host string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens += t.policy.Rate
+	if t.tokens > hedgeBurst {
+		t.tokens = hedgeBurst
+	}
+	if t.policy.Delay > 0 {
+		return t.policy.Delay, true
+	}
+	w := t.latencies[host]
+	if w == nil || w.count < t.policy.MinSamples {
+		return 0, false
+	}
+	return w.p95, true
+}
+
+func (t *hedgeTransport) observe(host string, elapsed time.Duration) {
+	if t.policy.Delay > 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.latencies[host]
+	if w == nil {
+		if t.latencies == nil {
+			t.latencies = map[string]*latencyWindow{}
+		}
+		w = &latencyWindow{}
+		t.latencies[host] = w
+	}
+	w.add(elapsed)
+}
+
+// latencyWindow keeps the latest latencies of a host and their p95.
+type latencyWindow struct {
+	samples [latencySamples]time.Duration
+	next    int
+	count   int
+	p95     time.Duration
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencySamples
+	if w.count < latencySamples {
+		w.count++
+	}
+	// sorting on every request is wasteful, the p95 moves slowly
+	if w.count < latencySamples || w.next%16 == 0 {
+		sorted := make([]time.Duration, w.count)
+		copy(sorted, w.samples[:w.count])
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		w.p95 = sorted[(w.count*95-1)/100]
+	}
+}
+
+// discard closes the responses of the n attempts which lost.
+func discard(results chan hedgeResult, n int) {
+	for ; n > 0; n-- {
+		closeResult(<-results)
+	}
+}
+
+func closeResult(r hedgeResult) {
+	r.cancel()
+	if r.resp != nil {
+		drainBody(r.resp)
+	}
+}
+
+// cancelBody cancels the context of an attempt when its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,240 @@
+package http_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zhlls/go-common/balancer"
+	httpclient "github.com/zhlls/go-common/client/http"
+	"github.com/zhlls/go-common/metrics"
+)
+
+func init() {
+	metrics.Init("clienttest")
+}
+
+// hedgeCount returns the clienttest_client_<name> counter of host.
+func hedgeCount(t *testing.T, name, host string) float64 {
+	t.Helper()
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, mf := range mfs {
+		if mf.GetName() != "clienttest_client_"+name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "host" && l.GetValue() == host {
+					total += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return total
+}
+
+// slowServer answers after delay, or when the request is cancelled, which
+// it reports on cancelled.
+type slowServer struct {
+	delay     time.Duration
+	hits      int32
+	cancelled chan struct{}
+}
+
+func newSlowServer(t *testing.T, delay time.Duration) (*slowServer, *httptest.Server) {
+	s := &slowServer{delay: delay, cancelled: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.hits, 1)
+		select {
+		case <-time.After(s.delay):
+		case <-r.Context().Done():
+			s.cancelled <- struct{}{}
+			return
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *slowServer) count() int {
+	return int(atomic.LoadInt32(&s.hits))
+}
+
+func newHedgeClient(t *testing.T, opts httpclient.Options) *httpclient.Client {
+	c, err := httpclient.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.CloseIdleConnections)
+	return c
+}
+
+func TestHedgeGoesToAnotherEndpoint(t *testing.T) {
+	// the first request is slow wherever it goes, all others are fast
+	var mu sync.Mutex
+	var served []string
+	entered := make(chan struct{})
+	cancelled := make(chan struct{}, 1)
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			served = append(served, name)
+			first := len(served) == 1
+			mu.Unlock()
+			if first {
+				close(entered)
+				<-r.Context().Done()
+				cancelled <- struct{}{}
+			}
+		}
+	}
+	srvA := httptest.NewServer(handler("a"))
+	defer srvA.Close()
+	srvB := httptest.NewServer(handler("b"))
+	defer srvB.Close()
+
+	bal := newServiceBalancer(t, balancer.Options{},
+		srvA.Listener.Addr().String(), srvB.Listener.Addr().String())
+	c := newHedgeClient(t, httpclient.Options{
+		Services: map[string]*balancer.Balancer{"hedge-service": bal},
+		Hedge:    &httpclient.HedgePolicy{Delay: 200 * time.Millisecond, Rate: 1},
+	})
+	hedges := hedgeCount(t, "hedge_total", "hedge-service")
+	won := hedgeCount(t, "hedge_won_total", "hedge-service")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Get(context.Background(), "http://hedge-service/")
+		done <- err
+	}()
+	<-entered
+	// another request moves round robin on, so the hedge would be sent to
+	// the endpoint of the first attempt again
+	if _, err := c.Get(context.Background(), "http://hedge-service/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("the losing attempt was not cancelled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(served) != 3 || served[2] == served[0] {
+		t.Errorf("requests served by %v, want the hedge on another endpoint than the first", served)
+	}
+	if got := hedgeCount(t, "hedge_total", "hedge-service") - hedges; got != 1 {
+		t.Errorf("hedges issued %v, want 1", got)
+	}
+	if got := hedgeCount(t, "hedge_won_total", "hedge-service") - won; got != 1 {
+		t.Errorf("hedges won %v, want 1", got)
+	}
+}
+
+func TestHedgeWaitsForP95(t *testing.T) {
+	var slow int32
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		// only the first attempt of a slow request is slow
+		if atomic.CompareAndSwapInt32(&slow, 1, 0) {
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer srv.Close()
+	c := newHedgeClient(t, httpclient.Options{
+		Hedge: &httpclient.HedgePolicy{Rate: 1, MinSamples: 5},
+	})
+	get := func(slowRequest bool) time.Duration {
+		t.Helper()
+		if slowRequest {
+			atomic.StoreInt32(&slow, 1)
+		}
+		start := time.Now()
+		if _, err := c.Get(context.Background(), srv.URL); err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start)
+	}
+
+	for i := 0; i < 4; i++ {
+		get(false)
+	}
+	if elapsed := get(true); elapsed < 100*time.Millisecond {
+		t.Errorf("slow request took %v before MinSamples, want it not hedged", elapsed)
+	}
+	if got := atomic.LoadInt32(&hits); got != 5 {
+		t.Errorf("requests %d before MinSamples, want 5", got)
+	}
+
+	// enough fast latencies to push the slow one out of the p95
+	for i := 0; i < 20; i++ {
+		get(false)
+	}
+	atomic.StoreInt32(&hits, 0)
+	if elapsed := get(true); elapsed >= 100*time.Millisecond {
+		t.Errorf("slow request took %v, want it answered by the hedge", elapsed)
+	}
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("requests %d, want the original and a hedge", got)
+	}
+}
+
+func TestHedgeMaxHedges(t *testing.T) {
+	s, srv := newSlowServer(t, 200*time.Millisecond)
+	// a Rate above MaxHedges leaves the limit to MaxHedges
+	c := newHedgeClient(t, httpclient.Options{
+		Hedge: &httpclient.HedgePolicy{Delay: 10 * time.Millisecond, MaxHedges: 2, Rate: 3},
+	})
+	if _, err := c.Get(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if s.count() != 3 {
+		t.Errorf("requests %d, want the original and 2 hedges", s.count())
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-s.cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("losing attempts were not cancelled")
+		}
+	}
+}
+
+func TestHedgeRate(t *testing.T) {
+	s, srv := newSlowServer(t, 30*time.Millisecond)
+	host := srv.Listener.Addr().String()
+	c := newHedgeClient(t, httpclient.Options{
+		Hedge: &httpclient.HedgePolicy{Delay: 5 * time.Millisecond, Rate: 0.5},
+	})
+	hedges := hedgeCount(t, "hedge_total", host)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Get(context.Background(), srv.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every request earns half a hedge
+	if got := s.count() - 10; got != 5 {
+		t.Errorf("hedges sent %d, want 5 of 10 requests", got)
+	}
+	if got := hedgeCount(t, "hedge_total", host) - hedges; got != 5 {
+		t.Errorf("hedges issued %v, want 5", got)
+	}
+}
@@ -0,0 +1,264 @@
+// Package httptest replaces the network in tests of code using client/http.
+//
+// A Mock answers requests matching its stubs with canned responses:
+//
+//	m := httptest.NewMock(t)
+//	m.On(httptest.Method("GET"), httptest.Path("/users/1")).
+//		ReplyJSON(200, map[string]interface{}{"name": "alice"})
+//	httptest.UseDefault(t, m)
+//
+//	data, err := http.SimpleTraceGet(ctx, "http://user-service/users/1")
+//
+// A Recorder sends requests to the real servers and saves the exchanges to a
+// fixture file, which a Replayer serves offline afterwards. RecordOrReplay
+// picks one by the HTTPTEST_RECORD environment variable:
+//
+//	rt := httptest.RecordOrReplay(t, "testdata/users.json")
+//	c, err := http.NewClient(http.Options{Transport: rt})
+//
+// Requests nothing answers fail the test and return ErrUnmatched.
+package httptest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	httpclient "github.com/zhlls/go-common/client/http"
+	"github.com/zhlls/go-common/utils"
+)
+
+// ErrUnmatched is returned for requests no stub or fixture answers.
+var ErrUnmatched = errors.New("httptest: unmatched request")
+
+// UseDefault makes the default client of client/http, used by
+// SimpleTraceDo, send its requests to rt until the test ends.
+func UseDefault(t testing.TB, rt http.RoundTripper) {
+	t.Helper()
+	c, err := httpclient.NewClient(httpclient.Options{Transport: rt})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	prev := httpclient.DefaultClient()
+	httpclient.SetDefaultClient(c)
+	t.Cleanup(func() {
+		httpclient.SetDefaultClient(prev)
+	})
+}
+
+// Matcher decides whether a stub answers a request, body is the request
+// body already read.
+type Matcher func(req *http.Request, body []byte) bool
+
+// Method matches the request method.
+func Method(method string) Matcher {
+	return func(req *http.Request, _ []byte) bool {
+		return req.Method == method
+	}
+}
+
+// Path matches the URL path exactly.
+func Path(path string) Matcher {
+	return func(req *http.Request, _ []byte) bool {
+		return req.URL.Path == path
+	}
+}
+
+// PathPrefix matches URL paths starting with prefix.
+func PathPrefix(prefix string) Matcher {
+	return func(req *http.Request, _ []byte) bool {
+		return strings.HasPrefix(req.URL.Path, prefix)
+	}
+}
+
+// Query matches a query argument.
+func Query(key, value string) Matcher {
+	return func(req *http.Request, _ []byte) bool {
+		return req.URL.Query().Get(key) == value
+	}
+}
+
+// Header matches a request header.
+func Header(key, value string) Matcher {
+	return func(req *http.Request, _ []byte) bool {
+		return req.Header.Get(key) == value
+	}
+}
+
+// Body matches the raw request body.
+func Body(body []byte) Matcher {
+	return func(_ *http.Request, b []byte) bool {
+		return bytes.Equal(b, body)
+	}
+}
+
+// BodyContains matches request bodies containing s.
+func BodyContains(s string) Matcher {
+	return func(_ *http.Request, b []byte) bool {
+		return bytes.Contains(b, []byte(s))
+	}
+}
+
+// BodyJSON matches request bodies equal to v as JSON, regardless of the
+// order of keys and white space.
+func BodyJSON(v interface{}) Matcher {
+	want, err := normalizeJSON(v)
+	return func(_ *http.Request, b []byte) bool {
+		if err != nil {
+			return false
+		}
+		var got interface{}
+		if utils.JsonUnmarshal(b, &got) != nil {
+			return false
+		}
+		return reflect.DeepEqual(got, want)
+	}
+}
+
+func normalizeJSON(v interface{}) (interface{}, error) {
+	data, err := utils.JsonMarshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = utils.JsonUnmarshal(data, &out)
+	return out, err
+}
+
+// Stub is a canned response of a Mock, 200 with an empty body by default.
+type Stub struct {
+	m        *Mock
+	matchers []Matcher
+	status   int
+	header   http.Header
+	body     []byte
+	err      error
+	calls    int
+}
+
+// Reply sets the status and body of the response.
+func (s *Stub) Reply(status int, body []byte) *Stub {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.status, s.body = status, body
+	return s
+}
+
+// ReplyJSON sets the status and the body marshalled from v, with a JSON
+// content type.
+func (s *Stub) ReplyJSON(status int, v interface{}) *Stub {
+	s.m.t.Helper()
+	data, err := utils.JsonMarshal(v)
+	if err != nil {
+		s.m.t.Fatalf("marshal response body: %v", err)
+	}
+	s.WithHeader("Content-Type", "application/json")
+	return s.Reply(status, data)
+}
+
+// WithHeader sets a response header.
+func (s *Stub) WithHeader(key, value string) *Stub {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.header.Set(key, value)
+	return s
+}
+
+// ReplyError fails the request with err instead of a response, e.g. to
+// test retries.
+func (s *Stub) ReplyError(err error) *Stub {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.err = err
+	return s
+}
+
+// Calls returns the number of requests the stub answered.
+func (s *Stub) Calls() int {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.calls
+}
+
+// Mock is a RoundTripper answering requests with the first stub all of
+// whose matchers match.
+type Mock struct {
+	t testing.TB
+
+	mu    sync.Mutex
+	stubs []*Stub
+}
+
+// NewMock creates a Mock without stubs.
+func NewMock(t testing.TB) *Mock {
+	return &Mock{t: t}
+}
+
+// On adds a stub answering requests which match all matchers.
+func (m *Mock) On(matchers ...Matcher) *Stub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &Stub{m: m, matchers: matchers, status: http.StatusOK, header: http.Header{}}
+	m.stubs = append(m.stubs, s)
+	return s
+}
+
+// RoundTrip answers req by its stub.
+func (m *Mock) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.stubs {
+		if !matchAll(s.matchers, req, body) {
+			continue
+		}
+		s.calls++
+		if s.err != nil {
+			return nil, s.err
+		}
+		return newResponse(req, s.status, s.header.Clone(), s.body), nil
+	}
+	m.t.Errorf("httptest: no stub matches %s %s", req.Method, req.URL.Redacted())
+	return nil, fmt.Errorf("%w: %s %s", ErrUnmatched, req.Method, req.URL.Redacted())
+}
+
+func matchAll(matchers []Matcher, req *http.Request, body []byte) bool {
+	for _, match := range matchers {
+		if !match(req, body) {
+			return false
+		}
+	}
+	return true
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return ioutil.ReadAll(req.Body)
+}
+
+func newResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
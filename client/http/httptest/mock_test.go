@@ -0,0 +1,213 @@
+package httptest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	nethttptest "net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+
+	httpclient "github.com/zhlls/go-common/client/http"
+	"github.com/zhlls/go-common/client/http/httptest"
+	"github.com/zhlls/go-common/metrics"
+)
+
+func init() {
+	metrics.Init("httptest")
+}
+
+func TestMockCapturesSpansAndMetrics(t *testing.T) {
+	tracer := mocktracer.New()
+	prev := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(prev)
+
+	m := httptest.NewMock(t)
+	stub := m.On(httptest.Method("GET"), httptest.Path("/users/1")).
+		ReplyJSON(http.StatusOK, map[string]interface{}{"name": "alice"})
+	httptest.UseDefault(t, m)
+
+	before := requestTotal(t, "user-service")
+	var out struct {
+		Name string `json:"name"`
+	}
+	if err := httpclient.GetJSON(context.Background(), "http://user-service/users/1", &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "alice" {
+		t.Errorf("name: got %q, want alice", out.Name)
+	}
+	if stub.Calls() != 1 {
+		t.Errorf("stub calls: got %d, want 1", stub.Calls())
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 {
+		t.Fatalf("spans: got %d, want 1", len(spans))
+	}
+	if got := spans[0].Tag("http.url"); got != "http://user-service/users/1" {
+		t.Errorf("span url: got %v", got)
+	}
+	if got := spans[0].Tag("http.status_code"); got != uint16(http.StatusOK) {
+		t.Errorf("span status: got %v, want 200", got)
+	}
+
+	if got := requestTotal(t, "user-service") - before; got != 1 {
+		t.Errorf("client request_total: got %v more, want 1", got)
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	srv := nethttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte("hello " + r.URL.Query().Get("name")))
+	}))
+	defer srv.Close()
+	fixture := filepath.Join(t.TempDir(), "hello.json")
+
+	t.Run("record", func(t *testing.T) {
+		c, err := httpclient.NewClient(httpclient.Options{Transport: httptest.NewRecorder(t, fixture)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := c.Get(context.Background(), srv.URL+"/?name=bob")
+		if err != nil || string(body) != "hello bob" {
+			t.Fatalf("got %q, %v", body, err)
+		}
+	})
+
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("fixture not redacted: %s", data)
+	}
+
+	srv.Close()
+	t.Run("replay", func(t *testing.T) {
+		c, err := httpclient.NewClient(httpclient.Options{Transport: httptest.NewReplayer(t, fixture)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Send(context.Background(), http.MethodGet, srv.URL+"/?name=bob", nil)
+		if err != nil || string(resp) != "hello bob" {
+			t.Fatalf("got %q, %v", resp, err)
+		}
+	})
+}
+
+func requestTotal(t *testing.T, host string) float64 {
+	t.Helper()
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, mf := range mfs {
+		if mf.GetName() != "httptest_client_request_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "host" && l.GetValue() == host {
+					total += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return total
+}
+
+func TestRecordBinaryBody(t *testing.T) {
+	reqBody := []byte{0xfe, 0x01, 'a'}
+	respBody := []byte{0xff, 0x00, 0x80}
+	srv := nethttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, _ := ioutil.ReadAll(r.Body); !bytes.Equal(got, reqBody) {
+			t.Errorf("server got %x", got)
+		}
+		_, _ = w.Write(respBody)
+	}))
+	defer srv.Close()
+	fixture := filepath.Join(t.TempDir(), "binary.json")
+
+	send := func(t *testing.T, rt http.RoundTripper) {
+		c, err := httpclient.NewClient(httpclient.Options{Transport: rt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := c.Send(context.Background(), http.MethodPut, srv.URL+"/blob", bytes.NewReader(reqBody))
+		if err != nil || !bytes.Equal(body, respBody) {
+			t.Fatalf("got %x, %v", body, err)
+		}
+	}
+	t.Run("record", func(t *testing.T) {
+		send(t, httptest.NewRecorder(t, fixture))
+	})
+
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exchanges []httptest.Exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		t.Fatal(err)
+	}
+	if e := exchanges[0]; !e.Request.BodyBase64 || !e.Response.BodyBase64 {
+		t.Errorf("fixture %s, want base64 bodies", data)
+	}
+
+	srv.Close()
+	t.Run("replay", func(t *testing.T) {
+		send(t, httptest.NewReplayer(t, fixture))
+	})
+}
+
+func TestRecordRedactsQuery(t *testing.T) {
+	srv := nethttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.URL.Query().Get("name")))
+	}))
+	defer srv.Close()
+	fixture := filepath.Join(t.TempDir(), "query.json")
+
+	t.Run("record", func(t *testing.T) {
+		c, err := httpclient.NewClient(httpclient.Options{
+			Transport: httptest.NewRecorder(t, fixture, httptest.RedactQuery("session")),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := c.Get(context.Background(), srv.URL+"/?name=bob&API_KEY=key-123&session=sess-456")
+		if err != nil || string(body) != "hello bob" {
+			t.Fatalf("got %q, %v", body, err)
+		}
+	})
+
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("key-123")) || bytes.Contains(data, []byte("sess-456")) {
+		t.Errorf("fixture not redacted: %s", data)
+	}
+
+	srv.Close()
+	t.Run("replay", func(t *testing.T) {
+		c, err := httpclient.NewClient(httpclient.Options{Transport: httptest.NewReplayer(t, fixture)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a redacted value matches whatever the value
+		body, err := c.Get(context.Background(), srv.URL+"/?session=other&name=bob&API_KEY=key-789")
+		if err != nil || string(body) != "hello bob" {
+			t.Fatalf("got %q, %v", body, err)
+		}
+	})
+}
@@ -0,0 +1,344 @@
+package httptest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// RecordEnv is the environment variable which makes RecordOrReplay record.
+const RecordEnv = "HTTPTEST_RECORD"
+
+const redacted = "REDACTED"
+
+// DefaultRedactedHeaders are the headers whose values are never written to
+// fixtures.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// DefaultRedactedQuery are the query parameters whose values are never
+// written to fixtures, compared ignoring case.
+var DefaultRedactedQuery = []string{
+	"access_token",
+	"api_key",
+	"apikey",
+	"client_secret",
+	"password",
+	"secret",
+	"signature",
+	"token",
+}
+
+// Exchange is a recorded request and its response. Bodies are kept as text,
+// or base64 encoded with BodyBase64 set when they are not valid UTF-8.
+type Exchange struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the request of an Exchange.
+type RecordedRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+// RecordedResponse is the response of an Exchange.
+type RecordedResponse struct {
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+// RecordOption configures a Recorder.
+type RecordOption func(*Recorder)
+
+// RedactHeaders adds headers whose values are replaced in the fixture, in
+// addition to DefaultRedactedHeaders.
+func RedactHeaders(keys ...string) RecordOption {
+	return func(r *Recorder) {
+		r.redact = append(r.redact, keys...)
+	}
+}
+
+// RedactQuery adds query parameters whose values are replaced in the
+// fixture, in addition to DefaultRedactedQuery. A replayed request matches
+// whatever its value of a redacted parameter.
+func RedactQuery(keys ...string) RecordOption {
+	return func(r *Recorder) {
+		r.redactQuery = append(r.redactQuery, keys...)
+	}
+}
+
+// WithTransport sets the transport requests are recorded from, the default
+// is http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) RecordOption {
+	return func(r *Recorder) {
+		r.next = rt
+	}
+}
+
+// RecordOrReplay returns a Recorder writing path when the RecordEnv
+// environment variable is set, otherwise a Replayer serving path.
+func RecordOrReplay(t testing.TB, path string, opts ...RecordOption) http.RoundTripper {
+	t.Helper()
+	if os.Getenv(RecordEnv) != "" {
+		return NewRecorder(t, path, opts...)
+	}
+	return NewReplayer(t, path)
+}
+
+// Recorder is a RoundTripper sending requests to the real servers and
+// saving the exchanges to a fixture file when the test ends.
+type Recorder struct {
+	t           testing.TB
+	path        string
+	next        http.RoundTripper
+	redact      []string
+	redactQuery []string
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// NewRecorder creates a Recorder writing the fixture at path.
+func NewRecorder(t testing.TB, path string, opts ...RecordOption) *Recorder {
+	r := &Recorder{
+		t:           t,
+		path:        path,
+		next:        http.DefaultTransport,
+		redact:      append([]string(nil), DefaultRedactedHeaders...),
+		redactQuery: append([]string(nil), DefaultRedactedQuery...),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	t.Cleanup(r.save)
+	return r
+}
+
+// RoundTrip sends req and records the exchange.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(req.Context())
+	if reqBody != nil {
+		out.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	e := Exchange{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    r.redactURL(req.URL),
+			Header: r.redactHeader(req.Header),
+		},
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: r.redactHeader(resp.Header),
+		},
+	}
+	e.Request.Body, e.Request.BodyBase64 = encodeBody(reqBody)
+	e.Response.Body, e.Response.BodyBase64 = encodeBody(respBody)
+
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, e)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, key := range r.redact {
+		if _, ok := h[http.CanonicalHeaderKey(key)]; ok {
+			h.Set(key, redacted)
+		}
+	}
+	return h
+}
+
+// redactURL returns u without password and with the values of the redacted
+// query parameters replaced.
+func (r *Recorder) redactURL(u *url.URL) string {
+	query := u.Query()
+	found := false
+	for key, values := range query {
+		for _, k := range r.redactQuery {
+			if strings.EqualFold(key, k) {
+				for i := range values {
+					values[i] = redacted
+				}
+				found = true
+			}
+		}
+	}
+	if !found {
+		return u.Redacted()
+	}
+	c := *u
+	c.RawQuery = query.Encode()
+	return c.Redacted()
+}
+
+func (r *Recorder) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r.exchanges, "", "  ")
+	if err != nil {
+		r.t.Errorf("httptest: marshal fixture: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		r.t.Errorf("httptest: create fixture dir: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(r.path, append(data, '\n'), 0644); err != nil {
+		r.t.Errorf("httptest: write fixture: %v", err)
+	}
+}
+
+// Replayer is a RoundTripper serving the exchanges of a fixture file. A
+// request matches an exchange by method, URL and body. Identical requests
+// are answered in recorded order, the last answer repeats.
+type Replayer struct {
+	t testing.TB
+
+	mu        sync.Mutex
+	exchanges []Exchange
+	served    []bool
+}
+
+// NewReplayer creates a Replayer of the fixture at path, failing the test
+// when it cannot be read.
+func NewReplayer(t testing.TB, path string) *Replayer {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("httptest: read fixture: %v (record it with %s=1)", err, RecordEnv)
+	}
+	var exchanges []Exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		t.Fatalf("httptest: parse fixture %s: %v", path, err)
+	}
+	for i, e := range exchanges {
+		if _, err := decodeBody(e.Request.Body, e.Request.BodyBase64); err != nil {
+			t.Fatalf("httptest: fixture %s: request body of exchange %d: %v", path, i, err)
+		}
+		if _, err := decodeBody(e.Response.Body, e.Response.BodyBase64); err != nil {
+			t.Fatalf("httptest: fixture %s: response body of exchange %d: %v", path, i, err)
+		}
+	}
+	return &Replayer{t: t, exchanges: exchanges, served: make([]bool, len(exchanges))}
+}
+
+// RoundTrip answers req from the fixture.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	uri := req.URL.Redacted()
+	reqBody, reqBase64 := encodeBody(body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := -1
+	for i, e := range r.exchanges {
+		if e.Request.Method != req.Method || !matchURL(e.Request.URL, req.URL) ||
+			e.Request.Body != reqBody || e.Request.BodyBase64 != reqBase64 {
+			continue
+		}
+		last = i
+		if !r.served[i] {
+			break
+		}
+	}
+	if last < 0 {
+		r.t.Errorf("httptest: no recorded exchange matches %s %s", req.Method, uri)
+		return nil, fmt.Errorf("%w: %s %s", ErrUnmatched, req.Method, uri)
+	}
+	r.served[last] = true
+	resp := r.exchanges[last].Response
+	// checked by NewReplayer
+	respBody, _ := decodeBody(resp.Body, resp.BodyBase64)
+	return newResponse(req, resp.Status, resp.Header.Clone(), respBody), nil
+}
+
+// matchURL reports whether u is the recorded URL, a redacted query value
+// matches every value.
+func matchURL(recorded string, u *url.URL) bool {
+	if recorded == u.Redacted() {
+		return true
+	}
+	rec, err := url.Parse(recorded)
+	if err != nil {
+		return false
+	}
+	recURL, gotURL := *rec, *u
+	recURL.RawQuery, gotURL.RawQuery = "", ""
+	if recURL.String() != gotURL.Redacted() {
+		return false
+	}
+	recQuery, gotQuery := rec.Query(), u.Query()
+	if len(recQuery) != len(gotQuery) {
+		return false
+	}
+	for key, values := range recQuery {
+		got := gotQuery[key]
+		if len(got) != len(values) {
+			return false
+		}
+		for i, v := range values {
+			if v != redacted && v != got[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// encodeBody returns body as text, or base64 encoded when it is not valid
+// UTF-8, which JSON cannot keep.
+func encodeBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if !isBase64 {
+		return []byte(body), nil
+	}
+	return base64.StdEncoding.DecodeString(body)
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/zhlls/go-common/utils"
+)
+
+const (
+	contentTypeJSON = "application/json"
+
+	// maxEnvelopeSize limits the error bodies read for decoding an error
+	// envelope, they are small and longer bodies are something else.
+	maxEnvelopeSize = 64 << 10
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds
+// Options.MaxResponseSize.
+var ErrResponseTooLarge = errors.New("http response body too large")
+
+// APIError is returned for non-2xx responses carrying the error envelope
+// of server/http, {"msg": "..."} as written by Failed or
+// {"msg": "...", "result": ...} as written by BadRequestMap and the like.
+// It unwraps to the *StatusError of the response.
+type APIError struct {
+	StatusCode int
+	Msg        string
+	// Result is the raw result of the envelope, nil when absent.
+	Result json.RawMessage
+
+	status *StatusError
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d: %s", e.status.Method, e.status.URL, e.StatusCode, e.Msg)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.status
+}
+
+// envelope matches both errorMsg and failedMsg of server/http.
+type envelope struct {
+	Msg    *string         `json:"msg"`
+	Result json.RawMessage `json:"result"`
+}
+
+// GetJSON sends a GET request with the default Client and decodes the JSON
+// response into out.
+func GetJSON(ctx context.Context, uri string, out interface{}) error {
+	return defaultClient.GetJSON(ctx, uri, out)
+}
+
+// PostJSON posts in as JSON with the default Client and decodes the JSON
+// response into out.
+func PostJSON(ctx context.Context, uri string, in, out interface{}) error {
+	return defaultClient.PostJSON(ctx, uri, in, out)
+}
+
+// GetJSON sends a GET request for uri and decodes the JSON response into
+// out.
+func (c *Client) GetJSON(ctx context.Context, uri string, out interface{}) error {
+	return c.DoJSON(ctx, http.MethodGet, uri, nil, out)
+}
+
+// PostJSON posts in as JSON to uri and decodes the JSON response into out.
+func (c *Client) PostJSON(ctx context.Context, uri string, in, out interface{}) error {
+	return c.DoJSON(ctx, http.MethodPost, uri, in, out)
+}
+
+// DoJSON sends in encoded as JSON, unless nil, and decodes the response into
+// out, unless nil or the response is empty. Values implementing the easyjson
+// interfaces are encoded and decoded with easyjson.
+//
+// Non-2xx responses with an error envelope are returned as *APIError,
+// others as *StatusError. Response bodies larger than
+// Options.MaxResponseSize fail with ErrResponseTooLarge.
+func (c *Client) DoJSON(ctx context.Context, method, uri string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := utils.JsonMarshal(in)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := c.NewRequest(ctx, method, uri, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", contentTypeJSON)
+	}
+	req.Header.Set("Accept", contentTypeJSON)
+
+	resp, err := c.do(req, maxEnvelopeSize)
+	if err != nil {
+		return decodeEnvelope(err, c.opts.MaxErrorBodySize)
+	}
+	defer resp.Body.Close()
+
+	data, err := readLimited(resp.Body, c.opts.MaxResponseSize)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := utils.JsonUnmarshal(data, out); err != nil {
+		return fmt.Errorf("decode response of %s %s: %w", req.Method, req.URL.Redacted(), err)
+	}
+	return nil
+}
+
+// decodeEnvelope turns a *StatusError with an error envelope into an
+// *APIError, and cuts the body of the *StatusError to maxErrorBody.
+func decodeEnvelope(err error, maxErrorBody int) error {
+	var status *StatusError
+	if !errors.As(err, &status) {
+		return err
+	}
+	var env envelope
+	decoded := utils.JsonUnmarshal(status.Body, &env) == nil && env.Msg != nil
+	if len(status.Body) > maxErrorBody {
+		status.Body = status.Body[:maxErrorBody]
+	}
+	if !decoded {
+		return status
+	}
+	return &APIError{
+		StatusCode: status.StatusCode,
+		Msg:        *env.Msg,
+		Result:     env.Result,
+		status:     status,
+	}
+}
+
+// readLimited reads r up to max bytes, negative max reads all of it.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	if max < 0 {
+		return ioutil.ReadAll(r)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrResponseTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,179 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	httpclient "github.com/zhlls/go-common/client/http"
+)
+
+type user struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// jsonServer answers /echo with the request body and other paths with the
+// status and body registered for them.
+func jsonServer(t *testing.T, responses map[string]struct {
+	status int
+	body   string
+}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/echo" {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(body)
+			return
+		}
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	srv := jsonServer(t, map[string]struct {
+		status int
+		body   string
+	}{
+		"/user":    {http.StatusOK, `{"id":1,"name":"alice"}`},
+		"/empty":   {http.StatusNoContent, ""},
+		"/invalid": {http.StatusOK, `{"id":`},
+	})
+	forEachRequester(t, httpclient.Options{BaseURL: srv.URL}, func(t *testing.T, r httpclient.Requester) {
+		ctx := context.Background()
+		var got user
+		if err := r.GetJSON(ctx, "/user", &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != (user{1, "alice"}) {
+			t.Errorf("got %+v", got)
+		}
+
+		got = user{}
+		if err := r.PostJSON(ctx, "/echo", user{2, "bob"}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != (user{2, "bob"}) {
+			t.Errorf("echoed %+v", got)
+		}
+
+		if err := r.DoJSON(ctx, http.MethodDelete, "/empty", nil, &got); err != nil {
+			t.Errorf("empty response: %v", err)
+		}
+		if err := r.GetJSON(ctx, "/invalid", &got); err == nil || !strings.Contains(err.Error(), "decode response") {
+			t.Errorf("invalid response: %v, want a decode error", err)
+		}
+	})
+}
+
+func TestJSONHeaders(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer srv.Close()
+	forEachRequester(t, httpclient.Options{BaseURL: srv.URL}, func(t *testing.T, r httpclient.Requester) {
+		if err := r.PostJSON(context.Background(), "/", user{1, "alice"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if header.Get("Content-Type") != "application/json" || header.Get("Accept") != "application/json" {
+			t.Errorf("post headers %v", header)
+		}
+		if err := r.GetJSON(context.Background(), "/", nil); err != nil {
+			t.Fatal(err)
+		}
+		if header.Get("Accept") != "application/json" {
+			t.Errorf("get headers %v", header)
+		}
+	})
+}
+
+func TestJSONErrorEnvelope(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	srv := jsonServer(t, map[string]struct {
+		status int
+		body   string
+	}{
+		"/error":  {http.StatusNotFound, `{"msg":"user not found"}`},
+		"/failed": {http.StatusBadRequest, `{"msg":"invalid","result":{"name":"required"}}`},
+		// longer than MaxErrorBodySize
+		"/long":  {http.StatusConflict, `{"msg":"` + long + `"}`},
+		"/plain": {http.StatusBadGateway, "upstream down " + long},
+	})
+	opts := httpclient.Options{BaseURL: srv.URL, MaxErrorBodySize: 20}
+	forEachRequester(t, opts, func(t *testing.T, r httpclient.Requester) {
+		ctx := context.Background()
+		for _, tc := range []struct {
+			path   string
+			status int
+			msg    string
+			result string
+		}{
+			{"/error", http.StatusNotFound, "user not found", ""},
+			{"/failed", http.StatusBadRequest, "invalid", `{"name":"required"}`},
+			{"/long", http.StatusConflict, long, ""},
+		} {
+			err := r.GetJSON(ctx, tc.path, nil)
+			var apiErr *httpclient.APIError
+			if !errors.As(err, &apiErr) {
+				t.Errorf("%s: got %v, want an *APIError", tc.path, err)
+				continue
+			}
+			if apiErr.StatusCode != tc.status || apiErr.Msg != tc.msg || string(apiErr.Result) != tc.result {
+				t.Errorf("%s: got %+v", tc.path, apiErr)
+			}
+			var statusErr *httpclient.StatusError
+			if !errors.As(err, &statusErr) || len(statusErr.Body) > 20 {
+				t.Errorf("%s: status error %v, want the body cut to 20 bytes", tc.path, statusErr)
+			}
+		}
+
+		err := r.GetJSON(ctx, "/plain", nil)
+		var apiErr *httpclient.APIError
+		if errors.As(err, &apiErr) {
+			t.Errorf("plain error decoded as %+v", apiErr)
+		}
+		var statusErr *httpclient.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway ||
+			string(statusErr.Body) != "upstream down xxxxxx" {
+			t.Errorf("plain error %v, want a *StatusError with the start of the body", err)
+		}
+	})
+}
+
+func TestJSONMaxResponseSize(t *testing.T) {
+	srv := jsonServer(t, map[string]struct {
+		status int
+		body   string
+	}{
+		"/large": {http.StatusOK, `{"name":"` + strings.Repeat("x", 100) + `"}`},
+	})
+	opts := httpclient.Options{BaseURL: srv.URL, MaxResponseSize: 64}
+	forEachRequester(t, opts, func(t *testing.T, r httpclient.Requester) {
+		var got user
+		if err := r.GetJSON(context.Background(), "/large", &got); !errors.Is(err, httpclient.ErrResponseTooLarge) {
+			t.Errorf("got %v, want ErrResponseTooLarge", err)
+		}
+	})
+
+	opts.MaxResponseSize = -1
+	forEachRequester(t, opts, func(t *testing.T, r httpclient.Requester) {
+		var got map[string]json.RawMessage
+		if err := r.GetJSON(context.Background(), "/large", &got); err != nil {
+			t.Errorf("unlimited: %v", err)
+		}
+	})
+}
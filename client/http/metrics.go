@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zhlls/go-common/metrics"
+)
+
+// routeOther labels requests made without WithRoute, so that raw paths
+// never become label values.
+const routeOther = "other"
+
+// WithRoute sets the route template of requests made with the returned
+// context, e.g. "/users/{id}", used as label of the client metrics.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey, route)
+}
+
+func routeOf(ctx context.Context) string {
+	if route, ok := ctx.Value(routeKey).(string); ok && route != "" {
+		return route
+	}
+	return routeOther
+}
+
+// statusClass returns "2xx" for 200 and the like.
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return strconv.Itoa(code)
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// metricsTransport records every attempt of a request with the metrics
+// package, including the DNS, connect and TLS phases of new connections.
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	method := req.Method
+	route := routeOf(req.Context())
+
+	// connects to several addresses may race, the phases are timed from
+	// their first start
+	var mu sync.Mutex
+	var dnsStart, connectStart, tlsStart time.Time
+	started := func(t *time.Time) {
+		mu.Lock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+		mu.Unlock()
+	}
+	done := func(t *time.Time, phase string) {
+		mu.Lock()
+		start := *t
+		mu.Unlock()
+		if !start.IsZero() {
+			metrics.CollectHTTPClientConnPhase(host, phase, time.Since(start).Seconds())
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			started(&dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			done(&dnsStart, "dns")
+		},
+		ConnectStart: func(network, addr string) {
+			started(&connectStart)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				done(&connectStart, "connect")
+			}
+		},
+		TLSHandshakeStart: func() {
+			started(&tlsStart)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				done(&tlsStart, "tls")
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	// a request is in flight until its body is read or closed
+	metrics.CollectHTTPClientInFlight(host, 1)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		metrics.CollectHTTPClientRequest(host, method, route, "error", time.Since(start).Seconds())
+		metrics.CollectHTTPClientInFlight(host, -1)
+		return nil, err
+	}
+	status := statusClass(resp.StatusCode)
+	onBodyClose(resp, func() {
+		metrics.CollectHTTPClientRequest(host, method, route, status, time.Since(start).Seconds())
+		metrics.CollectHTTPClientInFlight(host, -1)
+	})
+	return resp, nil
+}
@@ -0,0 +1,134 @@
+package http_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	httpclient "github.com/zhlls/go-common/client/http"
+)
+
+// clientMetric returns the clienttest_client_<name> metric whose labels
+// include labels, nil when there is none.
+func clientMetric(t *testing.T, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "clienttest_client_"+name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for k, v := range labels {
+				found := false
+				for _, l := range m.GetLabel() {
+					if l.GetName() == k && l.GetValue() == v {
+						found = true
+					}
+				}
+				if !found {
+					continue metrics
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+func hostOf(srv *httptest.Server) string {
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestMetricsUntilBodyClosed(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer srv.Close()
+	host := hostOf(srv)
+	c := newHedgeClient(t, httpclient.Options{})
+
+	req, err := c.NewRequest(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := clientMetric(t, "in_flight_requests", map[string]string{"host": host}); m.GetGauge().GetValue() != 1 {
+		t.Errorf("in flight %v while reading the body, want 1", m)
+	}
+	if m := clientMetric(t, "request_total", map[string]string{"host": host}); m != nil {
+		t.Errorf("request counted before its body was read: %v", m)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if m := clientMetric(t, "in_flight_requests", map[string]string{"host": host}); m.GetGauge().GetValue() != 0 {
+		t.Errorf("in flight %v after closing the body, want 0", m)
+	}
+	m := clientMetric(t, "request_duration_seconds", map[string]string{"host": host, "status": "2xx"})
+	if m.GetHistogram().GetSampleCount() != 1 || m.GetHistogram().GetSampleSum() < 0.05 {
+		t.Errorf("duration %v, want one request including reading the body", m)
+	}
+}
+
+func TestMetricsLabels(t *testing.T) {
+	srv := echoServer(t)
+	host := hostOf(srv)
+	c := newHedgeClient(t, httpclient.Options{BaseURL: srv.URL})
+
+	if _, err := c.Get(httpclient.WithRoute(context.Background(), "/users/{id}"), "/users/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(context.Background(), "/status/404"); err == nil {
+		t.Fatal("got no error for a 404")
+	}
+	for _, labels := range []map[string]string{
+		{"host": host, "method": "GET", "route": "/users/{id}", "status": "2xx"},
+		// raw paths never become labels
+		{"host": host, "method": "GET", "route": "other", "status": "4xx"},
+	} {
+		if m := clientMetric(t, "request_total", labels); m.GetCounter().GetValue() != 1 {
+			t.Errorf("%v: count %v, want 1", labels, m)
+		}
+	}
+	if m := clientMetric(t, "connection_phase_seconds", map[string]string{"host": host, "phase": "connect"}); m == nil {
+		t.Error("connect phase not observed")
+	}
+
+	// requests failing without a response end at once
+	refused := "127.0.0.1:1"
+	failed := func() float64 {
+		return clientMetric(t, "request_total", map[string]string{"host": refused, "status": "error"}).GetCounter().GetValue()
+	}
+	before := failed()
+	if _, err := c.Get(context.Background(), "http://"+refused+"/"); err == nil {
+		t.Fatal("request to a closed port succeeded")
+	}
+	if got := failed() - before; got != 1 {
+		t.Errorf("failed request counted %v times, want 1", got)
+	}
+	if m := clientMetric(t, "in_flight_requests", map[string]string{"host": refused}); m.GetGauge().GetValue() != 0 {
+		t.Errorf("in flight %v after a failed request, want 0", m)
+	}
+}
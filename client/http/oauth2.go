@@ -0,0 +1,214 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhlls/go-common/utils"
+)
+
+const (
+	defaultRefreshBefore = 30 * time.Second
+	defaultTokenTimeout  = 10 * time.Second
+	// defaultTokenLifetime is assumed when the token response has no
+	// expires_in
+	defaultTokenLifetime = time.Hour
+)
+
+// ClientCredentialsOptions configures ClientCredentials.
+type ClientCredentialsOptions struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+
+	// ClientID and ClientSecret authenticate the client with basic auth.
+	ClientID     string
+	ClientSecret string
+
+	// Scopes are requested for the token.
+	Scopes []string
+
+	// Params are sent with the token request in addition, e.g. audience.
+	Params url.Values
+
+	// RefreshBefore refreshes the token this long before it expires, 30s by
+	// default.
+	RefreshBefore time.Duration
+
+	// Client sends the token requests, a plain client with a 10s timeout
+	// by default.
+	Client *http.Client
+}
+
+// ClientCredentials is a Signer setting a bearer token obtained with the
+// OAuth2 client credentials grant. The token is cached and refreshed before
+// it expires, concurrent requests wait for a single refresh.
+type ClientCredentials struct {
+	opts ClientCredentialsOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	token    string
+	expires  time.Time
+	fetching *tokenFetch
+}
+
+// tokenFetch is a token request shared by the callers waiting for it.
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewClientCredentials creates ClientCredentials as configured by opts.
+func NewClientCredentials(opts ClientCredentialsOptions) *ClientCredentials {
+	if opts.RefreshBefore <= 0 {
+		opts.RefreshBefore = defaultRefreshBefore
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: defaultTokenTimeout}
+	}
+	return &ClientCredentials{opts: opts, now: time.Now}
+}
+
+// Sign sets the bearer token, fetching one when needed.
+func (c *ClientCredentials) Sign(req *http.Request) error {
+	token, err := c.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns the cached token, fetching a new one when it is about to
+// expire. The fetch is shared by concurrent callers, a caller whose ctx is
+// done stops waiting for it.
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if c.token != "" && c.now().Add(c.opts.RefreshBefore).Before(c.expires) {
+		token := c.token
+		c.mu.Unlock()
+		return token, nil
+	}
+	f := c.fetching
+	if f == nil {
+		f = &tokenFetch{done: make(chan struct{})}
+		c.fetching = f
+		go c.refresh(f)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-f.done:
+		return f.token, f.err
+	}
+}
+
+// refresh fetches a token for the waiting callers. It is detached from
+// their contexts, so one giving up does not fail the others, and limited by
+// the timeout of the token client instead.
+func (c *ClientCredentials) refresh(f *tokenFetch) {
+	timeout := c.opts.Client.Timeout
+	if timeout <= 0 {
+		timeout = defaultTokenTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	token, lifetime, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	if err == nil {
+		c.token, c.expires = token, c.now().Add(lifetime)
+	}
+	c.fetching = nil
+	c.mu.Unlock()
+
+	f.token, f.err = token, err
+	close(f.done)
+}
+
+// Invalidate drops the cached token, e.g. after the server rejected it.
+func (c *ClientCredentials) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// TokenError is returned when the token endpoint refuses a token.
+type TokenError struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *TokenError) Error() string {
+	msg := fmt.Sprintf("oauth2 token: %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+func (c *ClientCredentials) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{}
+	for k, vs := range c.opts.Params {
+		form[k] = vs
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(c.opts.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.opts.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", contentTypeJSON)
+	req.SetBasicAuth(url.QueryEscape(c.opts.ClientID), url.QueryEscape(c.opts.ClientSecret))
+
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := readLimited(resp.Body, 1<<20)
+	if err != nil {
+		return "", 0, fmt.Errorf("oauth2 token: %w", err)
+	}
+
+	var tr tokenResponse
+	decodeErr := utils.JsonUnmarshal(data, &tr)
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return "", 0, &TokenError{StatusCode: resp.StatusCode, Code: tr.Error, Description: tr.ErrorDescription}
+	}
+	if decodeErr != nil {
+		return "", 0, fmt.Errorf("oauth2 token: %w", decodeErr)
+	}
+	if tr.AccessToken == "" {
+		return "", 0, fmt.Errorf("oauth2 token: no access_token in response")
+	}
+	lifetime := time.Duration(tr.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	return tr.AccessToken, lifetime, nil
+}
@@ -0,0 +1,175 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer is a local token endpoint handing out "token-1", "token-2"...
+type tokenServer struct {
+	*httptest.Server
+	fetches int32
+	// release, when set, holds token responses until closed
+	release chan struct{}
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	ts := &tokenServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"unknown client"}`)
+			return
+		}
+		if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		if ts.release != nil {
+			<-ts.release
+		}
+		n := atomic.AddInt32(&ts.fetches, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *tokenServer) credentials(secret string) *ClientCredentials {
+	return NewClientCredentials(ClientCredentialsOptions{
+		TokenURL:     ts.URL,
+		ClientID:     "client",
+		ClientSecret: secret,
+		Scopes:       []string{"read", "write"},
+	})
+}
+
+func TestClientCredentialsSingleFetch(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	ts.release = make(chan struct{})
+	cc := ts.credentials("secret")
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	errs := make([]error, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = cc.Token(context.Background())
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(ts.release)
+	wg.Wait()
+
+	for i := range tokens {
+		if errs[i] != nil || tokens[i] != "token-1" {
+			t.Errorf("caller %d: %q, %v, want token-1", i, tokens[i], errs[i])
+		}
+	}
+	if token, _ := cc.Token(context.Background()); token != "token-1" {
+		t.Errorf("cached token %q, want token-1", token)
+	}
+	if n := atomic.LoadInt32(&ts.fetches); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+}
+
+func TestClientCredentialsCanceledCaller(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	ts.release = make(chan struct{})
+	cc := ts.credentials("secret")
+
+	waiting := make(chan string)
+	go func() {
+		token, _ := cc.Token(context.Background())
+		waiting <- token
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cc.Token(ctx); err != context.DeadlineExceeded {
+		t.Errorf("canceled caller: %v, want context.DeadlineExceeded", err)
+	}
+
+	// the fetch goes on for the other caller
+	close(ts.release)
+	if token := <-waiting; token != "token-1" {
+		t.Errorf("waiting caller got %q, want token-1", token)
+	}
+}
+
+func TestClientCredentialsRefresh(t *testing.T) {
+	ts := newTokenServer(t, 60)
+	cc := ts.credentials("secret")
+	now := time.Now()
+	cc.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		after time.Duration
+		want  string
+	}{
+		{0, "token-1"},
+		// RefreshBefore is 30s of the 60s lifetime
+		{29 * time.Second, "token-1"},
+		{31 * time.Second, "token-2"},
+	} {
+		now = now.Add(tc.after)
+		token, err := cc.Token(context.Background())
+		if err != nil || token != tc.want {
+			t.Errorf("after %v: %q, %v, want %s", tc.after, token, err, tc.want)
+		}
+		now = now.Add(-tc.after)
+	}
+}
+
+func TestClientCredentialsInvalidatedBy401(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if auth == "Bearer token-1" {
+			// revoked before its expiry
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	c, err := NewClient(Options{Signer: ts.credentials("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var se *StatusError
+	if _, err := c.Get(context.Background(), api.URL); !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("first request: %v, want 401", err)
+	}
+	if _, err := c.Get(context.Background(), api.URL); err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	if len(seen) != 2 || seen[1] != "Bearer token-2" {
+		t.Errorf("Authorization headers %q, want a new token after the 401", seen)
+	}
+}
+
+func TestClientCredentialsTokenError(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	_, err := ts.credentials("wrong").Token(context.Background())
+	var te *TokenError
+	if !errors.As(err, &te) {
+		t.Fatalf("error %v, want *TokenError", err)
+	}
+	want := TokenError{StatusCode: http.StatusUnauthorized, Code: "invalid_client", Description: "unknown client"}
+	if *te != want {
+		t.Errorf("token error %+v, want %+v", *te, want)
+	}
+}
@@ -0,0 +1,160 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/zhlls/go-common/client/http"
+)
+
+// forEachRequester runs test with a Client and a FastClient configured by
+// opts, the contract of Requester holds for both.
+func forEachRequester(t *testing.T, opts httpclient.Options, test func(t *testing.T, r httpclient.Requester)) {
+	c, err := httpclient.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := httpclient.NewFastClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []httpclient.Requester{c, fc} {
+		r := r
+		t.Run(fmt.Sprintf("%T", r)[1:], func(t *testing.T) {
+			defer r.CloseIdleConnections()
+			test(t, r)
+		})
+	}
+}
+
+// echoServer answers with the method, X-Team header and body of a request,
+// with the content type of a /type request and with the status of a
+// /status/<code> path.
+func echoServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var code int
+		if _, err := fmt.Sscanf(r.URL.Path, "/status/%d", &code); err == nil {
+			w.WriteHeader(code)
+			io.WriteString(w, "failed: "+strings.Repeat("x", 100))
+			return
+		}
+		if r.URL.Path == "/type" {
+			io.WriteString(w, r.Header.Get("Content-Type"))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("X-Team"), body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// blockingServer answers when the request is cancelled or the test ends.
+func blockingServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestRequesterSend(t *testing.T) {
+	srv := echoServer(t)
+	opts := httpclient.Options{BaseURL: srv.URL, Header: http.Header{"X-Team": {"core"}}}
+	forEachRequester(t, opts, func(t *testing.T, r httpclient.Requester) {
+		ctx := context.Background()
+		for _, tc := range []struct {
+			name string
+			send func() ([]byte, error)
+			want string
+		}{
+			{"get", func() ([]byte, error) { return r.Get(ctx, "/") }, "GET core "},
+			{"post", func() ([]byte, error) { return r.Post(ctx, "/", "text/plain", strings.NewReader("hi")) },
+				"POST core hi"},
+			{"post type", func() ([]byte, error) { return r.Post(ctx, "/type", "text/plain", nil) }, "text/plain"},
+			{"send", func() ([]byte, error) { return r.Send(ctx, http.MethodPut, "/", strings.NewReader("hi")) },
+				"PUT core hi"},
+		} {
+			got, err := tc.send()
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestRequesterStatusError(t *testing.T) {
+	srv := echoServer(t)
+	opts := httpclient.Options{BaseURL: srv.URL, MaxErrorBodySize: 10}
+	forEachRequester(t, opts, func(t *testing.T, r httpclient.Requester) {
+		_, err := r.Get(context.Background(), "/status/404")
+		var statusErr *httpclient.StatusError
+		if !errors.As(err, &statusErr) {
+			t.Fatalf("got %v, want a *StatusError", err)
+		}
+		if statusErr.StatusCode != http.StatusNotFound || statusErr.Method != http.MethodGet ||
+			statusErr.URL != srv.URL+"/status/404" || string(statusErr.Body) != "failed: xx" {
+			t.Errorf("status error %+v", statusErr)
+		}
+	})
+}
+
+func TestRequesterTimeout(t *testing.T) {
+	srv := blockingServer(t)
+	opts := httpclient.Options{BaseURL: srv.URL, Timeout: 50 * time.Millisecond}
+	forEachRequester(t, opts, func(t *testing.T, r httpclient.Requester) {
+		start := time.Now()
+		_, err := r.Get(context.Background(), "/")
+		var netErr interface{ Timeout() bool }
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("got %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("returned after %v, want the timeout", elapsed)
+		}
+	})
+}
+
+func TestRequesterContext(t *testing.T) {
+	srv := blockingServer(t)
+	opts := httpclient.Options{BaseURL: srv.URL}
+	forEachRequester(t, opts, func(t *testing.T, r httpclient.Requester) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := r.Get(ctx, "/"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("deadline: got %v, want context.DeadlineExceeded", err)
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		if _, err := r.Get(ctx, "/"); !errors.Is(err, context.Canceled) {
+			t.Errorf("cancel: got %v, want context.Canceled", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("cancel: returned after %v, want the cancellation", elapsed)
+		}
+
+		if _, err := r.Get(ctx, "/"); !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled: got %v, want context.Canceled", err)
+		}
+	})
+}
@@ -0,0 +1,301 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
+	"github.com/zhlls/go-common/metrics"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = 100 * time.Millisecond
+	defaultMaxDelay    = 5 * time.Second
+)
+
+type contextKey int
+
+const (
+	retryKey contextKey = iota
+	attemptKey
+	routeKey
+	hedgeKey
+	serviceKey
+	pickedKey
+)
+
+// DefaultRetryableStatus are the status codes retried when
+// RetryPolicy.RetryableStatus is empty.
+var DefaultRetryableStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures retries of failed requests. Only idempotent
+// methods, or requests with an Idempotency-Key header, are retried unless
+// RetryNonIdempotent is set or the request context comes from AllowRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first, 3 by
+	// default.
+	MaxAttempts int
+
+	// BaseDelay is the backoff before the first retry, doubled with every
+	// further retry and randomized with full jitter, 100ms by default.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the backoff, 5s by default. A longer Retry-After of the
+	// server ends the retries.
+	MaxDelay time.Duration
+
+	// RetryNonIdempotent retries requests of every method.
+	RetryNonIdempotent bool
+
+	// RetryableStatus are the response status codes which are retried,
+	// DefaultRetryableStatus when empty.
+	RetryableStatus []int
+
+	// RetryableError decides whether a transport error is retried,
+	// IsRetryableError when nil.
+	RetryableError func(err error) bool
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultMaxDelay
+	}
+	if len(p.RetryableStatus) == 0 {
+		p.RetryableStatus = DefaultRetryableStatus
+	}
+	if p.RetryableError == nil {
+		p.RetryableError = IsRetryableError
+	}
+	return p
+}
+
+// AllowRetry marks requests made with the returned context as safe to
+// retry whatever their method.
+func AllowRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey, true)
+}
+
+// IsRetryableError reports whether err is a transport error worth another
+// attempt: timeouts, refused or reset connections and connections closed
+// by the server. Cancelled contexts are never retried.
+func IsRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, fasthttp.ErrConnectionClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isIdempotent(req *http.Request) bool {
+	return idempotent(req.Context(), req.Method, req.Header.Get)
+}
+
+func idempotent(ctx context.Context, method string, header func(key string) string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if header("Idempotency-Key") != "" || header("X-Idempotency-Key") != "" {
+		return true
+	}
+	allow, _ := ctx.Value(retryKey).(bool)
+	return allow
+}
+
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.policy.RetryNonIdempotent && !isIdempotent(req) {
+		return t.next.RoundTrip(req)
+	}
+	req, err := rewindableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the span ends when the caller is done with the body
+	span, ctx := opentracing.StartSpanFromContext(req.Context(), "http.client", ext.SpanKindRPCClient)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.Redacted())
+
+	resp, err := t.attempts(ctx, req, span)
+	if err != nil {
+		ext.LogError(span, err)
+		span.Finish()
+		return nil, err
+	}
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+	onBodyClose(resp, span.Finish)
+	return resp, nil
+}
+
+// attempts sends req until it succeeds, fails with an error which is not
+// retried or runs out of attempts.
+func (t *retryTransport) attempts(ctx context.Context, req *http.Request, span opentracing.Span) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.WithContext(context.WithValue(ctx, attemptKey, attempt))
+		if attempt > 1 && req.GetBody != nil {
+			if attemptReq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = t.next.RoundTrip(attemptReq)
+		if attempt >= t.policy.MaxAttempts || !t.policy.retryable(statusOf(resp), err) {
+			break
+		}
+
+		delay := t.policy.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				if after > t.policy.MaxDelay {
+					break
+				}
+				delay = after
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			break
+		}
+
+		log.Debug("retry http request",
+			zap.String("method", req.Method),
+			zap.String("url", req.URL.Redacted()),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Int("status", statusOf(resp)),
+			zap.Error(err),
+		)
+		if resp != nil {
+			drainBody(resp)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		span.SetTag("http.retries", attempt)
+		metrics.CollectHTTPClientRetry(req.URL.Host, req.Method, routeOf(ctx))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (p RetryPolicy) retryable(status int, err error) bool {
+	if err != nil {
+		return p.RetryableError(err)
+	}
+	for _, code := range p.RetryableStatus {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the full jitter delay before retry number attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if shift := uint(attempt - 1); shift < 32 {
+		if d := p.BaseDelay << shift; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// rewindableBody returns req with a body which can be sent again, buffering
+// it when the request was not created from an in-memory reader.
+func rewindableBody(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req, nil
+}
+
+// retryAfter parses a Retry-After header, in seconds or as HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		d := time.Until(at)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// drainBody discards a response which is retried, so its connection can be
+// reused.
+func drainBody(resp *http.Response) {
+	_, _ = io.CopyN(ioutil.Discard, resp.Body, 4<<10)
+	_ = resp.Body.Close()
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,269 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+// flakyServer answers with the status codes of statuses in turn, then 200,
+// and records the bodies of the requests.
+type flakyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header
+	bodies   []string
+}
+
+func newFlakyServer(t *testing.T, statuses ...int) *flakyServer {
+	s := &flakyServer{statuses: statuses, header: http.Header{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		n := len(s.bodies)
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+		if n < len(s.statuses) {
+			for k, vs := range s.header {
+				w.Header()[k] = vs
+			}
+			w.WriteHeader(s.statuses[n])
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *flakyServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func newRetryClient(t *testing.T, policy RetryPolicy) *Client {
+	c, err := NewClient(Options{Retry: &policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.CloseIdleConnections)
+	return c
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}.withDefaults()
+	for _, tc := range []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond},
+		// the shift would overflow
+		{80, 50 * time.Millisecond},
+	} {
+		var max time.Duration
+		for i := 0; i < 1000; i++ {
+			d := p.backoff(tc.attempt)
+			if d < 0 || d > tc.ceiling {
+				t.Fatalf("attempt %d: backoff %v, want at most %v", tc.attempt, d, tc.ceiling)
+			}
+			if d > max {
+				max = d
+			}
+		}
+		// full jitter spreads over the whole range
+		if max < tc.ceiling/2 {
+			t.Errorf("attempt %d: longest backoff %v of %v", tc.attempt, max, tc.ceiling)
+		}
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"2", 2 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	} {
+		got, ok := retryAfter(tc.value)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%q: %v %v, want %v %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+	if got, ok := retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); !ok ||
+		got <= 58*time.Second || got > time.Minute {
+		t.Errorf("date a minute ahead: %v %v", got, ok)
+	}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	srv := newFlakyServer(t, http.StatusServiceUnavailable)
+	srv.header.Set("Retry-After", "1")
+	c := newRetryClient(t, RetryPolicy{BaseDelay: time.Millisecond})
+
+	start := time.Now()
+	if _, err := c.Get(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the Retry-After of 1s", elapsed)
+	}
+
+	// a Retry-After beyond MaxDelay ends the retries
+	srv = newFlakyServer(t, http.StatusServiceUnavailable)
+	srv.header.Set("Retry-After", "60")
+	_, err := c.Get(context.Background(), srv.URL)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %v, want the 503", err)
+	}
+	if n := len(srv.requests()); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestRetryIdempotency(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		method   string
+		header   string
+		ctx      func(context.Context) context.Context
+		policy   RetryPolicy
+		attempts int
+	}{
+		{name: "get", method: http.MethodGet, attempts: 2},
+		{name: "put", method: http.MethodPut, attempts: 2},
+		{name: "post", method: http.MethodPost, attempts: 1},
+		{name: "patch", method: http.MethodPatch, attempts: 1},
+		{name: "post with key", method: http.MethodPost, header: "Idempotency-Key", attempts: 2},
+		{name: "post with x key", method: http.MethodPost, header: "X-Idempotency-Key", attempts: 2},
+		{name: "post allowed", method: http.MethodPost, ctx: AllowRetry, attempts: 2},
+		{name: "post by policy", method: http.MethodPost, policy: RetryPolicy{RetryNonIdempotent: true}, attempts: 2},
+	} {
+		srv := newFlakyServer(t, http.StatusBadGateway)
+		tc.policy.BaseDelay = time.Millisecond
+		c := newRetryClient(t, tc.policy)
+		ctx := context.Background()
+		if tc.ctx != nil {
+			ctx = tc.ctx(ctx)
+		}
+		req, err := c.NewRequest(ctx, tc.method, srv.URL, strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.header != "" {
+			req.Header.Set(tc.header, "key-1")
+		}
+		if resp, err := c.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		if n := len(srv.requests()); n != tc.attempts {
+			t.Errorf("%s: sent %d requests, want %d", tc.name, n, tc.attempts)
+		}
+	}
+}
+
+// onceReader is a body which cannot be rewound by net/http.
+type onceReader struct {
+	io.Reader
+}
+
+func TestRetryRewindsBody(t *testing.T) {
+	srv := newFlakyServer(t, http.StatusBadGateway, http.StatusBadGateway)
+	c := newRetryClient(t, RetryPolicy{BaseDelay: time.Millisecond})
+
+	for _, body := range []io.Reader{strings.NewReader("payload"), onceReader{strings.NewReader("payload")}} {
+		srv.mu.Lock()
+		srv.bodies = nil
+		srv.mu.Unlock()
+		req, err := c.NewRequest(context.Background(), http.MethodPut, srv.URL, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		got := srv.requests()
+		if len(got) != 3 {
+			t.Fatalf("%T: sent %d requests, want 3", body, len(got))
+		}
+		for i, b := range got {
+			if b != "payload" {
+				t.Errorf("%T: attempt %d sent %q, want the whole body", body, i+1, b)
+			}
+		}
+	}
+}
+
+func TestRetrySpanCoversBody(t *testing.T) {
+	tracer := mocktracer.New()
+	prev := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(prev)
+
+	srv := newFlakyServer(t, http.StatusBadGateway)
+	c := newRetryClient(t, RetryPolicy{BaseDelay: time.Millisecond})
+	outer := func() *mocktracer.MockSpan {
+		for _, sp := range tracer.FinishedSpans() {
+			if sp.OperationName == "http.client" {
+				return sp
+			}
+		}
+		return nil
+	}
+
+	req, err := c.NewRequest(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp := outer(); sp != nil {
+		t.Error("span finished before the body was read")
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	sp := outer()
+	if sp == nil {
+		t.Fatal("span not finished after the body was closed")
+	}
+	if sp.Tag("http.retries") != 1 || sp.Tag("http.status_code") != uint16(http.StatusOK) {
+		t.Errorf("tags %v", sp.Tags())
+	}
+
+	// a failed request finishes the span at once
+	tracer.Reset()
+	_, err = c.Get(context.Background(), "http://127.0.0.1:1")
+	if err == nil {
+		t.Fatal("request to a closed port succeeded")
+	}
+	if sp := outer(); sp == nil || sp.Tag("error") != true {
+		t.Errorf("span %v of a failed request, want it finished with the error", sp)
+	}
+}
@@ -7,31 +7,53 @@ import (
 	"net/http"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
 )
 
-var client = http.Client{
-	Transport: &transport{
-		http.DefaultTransport,
-	},
+var defaultClient, _ = NewClient(Options{})
+
+// DefaultClient returns the Client used by SimpleTraceDo and the package
+// level JSON helpers.
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// SetDefaultClient replaces the Client used by SimpleTraceDo and the package
+// level JSON helpers, e.g. by one with a mock Transport in tests. It must
+// not be called while requests are in flight.
+func SetDefaultClient(c *Client) {
+	defaultClient = c
 }
 
+// SimpleTraceGet sends a GET request with the default Client.
 func SimpleTraceGet(ctx context.Context, uri string) ([]byte, error) {
 	return SimpleTraceDo(ctx, http.MethodGet, uri, nil)
 }
 
+// SimpleTracePost sends a POST request with the default Client.
 func SimpleTracePost(ctx context.Context, uri string, body io.Reader) ([]byte, error) {
 	return SimpleTraceDo(ctx, http.MethodPost, uri, body)
 }
 
+// SimpleTraceDo sends a request through the transport of the default Client
+// and returns the response body whatever its status. Unlike Client it keeps
+// the contract from before Client: no timeout beyond the deadline of ctx and
+// no *StatusError.
 func SimpleTraceDo(ctx context.Context, method, uri string, body io.Reader) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	c := defaultClient
+	req, err := c.NewRequest(ctx, method, uri, body)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := client.Do(req)
+	c.setDefaultHeaders(req)
+	resp, err := (&http.Client{Transport: c.http.Transport}).Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	return ioutil.ReadAll(resp.Body)
 }
 
@@ -40,14 +62,45 @@ type transport struct {
 }
 
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operationName := "http.client"
+	attempt, retried := req.Context().Value(attemptKey).(int)
+	if retried {
+		// a child of the span of the retryTransport
+		operationName = "http.client.attempt"
+	}
 	span, nextCtx := opentracing.StartSpanFromContext(
-		req.Context(), "http.client")
+		req.Context(), operationName, ext.SpanKindRPCClient)
 	defer span.Finish()
+	if retried {
+		span.SetTag("http.attempt", attempt)
+	}
+	if hedge, ok := req.Context().Value(hedgeKey).(int); ok {
+		span.SetTag("http.hedge", hedge)
+	}
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.Redacted())
+	ext.PeerHostname.Set(span, req.URL.Hostname())
 
+	// a RoundTripper must not modify the request of the caller
+	req = req.Clone(nextCtx)
 	err := opentracing.GlobalTracer().Inject(
-		opentracing.SpanFromContext(nextCtx).Context(), opentracing.HTTPHeaders,
+		span.Context(), opentracing.HTTPHeaders,
 		opentracing.HTTPHeadersCarrier(req.Header))
-	if err != nil {}
+	if err != nil {
+		log.Debug("trace inject failed",
+			zap.String("method", req.Method),
+			zap.String("url", req.URL.String()),
+			zap.Error(err))
+	}
 
-	return t.RoundTripper.RoundTrip(req)
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		ext.LogError(span, err)
+		return nil, err
+	}
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+	return resp, nil
 }
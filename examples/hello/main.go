@@ -1,20 +1,21 @@
 package main
 
 import (
+	"io"
 	syslog "log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	"github.com/opentracing/opentracing-go"
 	"github.com/uber/jaeger-client-go"
 	jaegerCfg "github.com/uber/jaeger-client-go/config"
-	"github.com/uber/jaeger-client-go/zipkin"
 
 	_ "github.com/zhlls/go-common/examples/hello/handler"
 
 	"github.com/zhlls/go-common/log"
 	"github.com/zhlls/go-common/server/http"
+	"github.com/zhlls/go-common/tracing"
 	"github.com/zhlls/go-common/utils"
 )
 
@@ -32,21 +33,29 @@ func main() {
 	}
 
 	// trace
-	cfg, err := jaegerCfg.FromEnv()
+	// e.g. TRACE_PROPAGATION=w3c,b3
+	formats, err := tracing.ParseFormats(os.Getenv("TRACE_PROPAGATION"))
 	if err != nil {
-		syslog.Fatalf("get trace config from env failed: \n%v", err)
+		syslog.Fatalf("get trace propagation from env failed: \n%v", err)
+	}
+	var closeTrace io.Closer
+	if os.Getenv("TRACE_BACKEND") == "otel" {
+		// e.g. TRACE_OTEL_EXPORTER=file TRACE_OTEL_FILE=/tmp/hello-spans.json
+		closeTrace, err = tracing.InitGlobalOTelTracer(tracing.OTelConfig{
+			ServiceName: "hello",
+			Exporter:    os.Getenv("TRACE_OTEL_EXPORTER"),
+			FilePath:    os.Getenv("TRACE_OTEL_FILE"),
+			Formats:     formats,
+		})
+	} else {
+		var cfg *jaegerCfg.Configuration
+		if cfg, err = jaegerCfg.FromEnv(); err != nil {
+			syslog.Fatalf("get trace config from env failed: \n%v", err)
+		}
+		cfg.Sampler.Type = jaeger.SamplerTypeConst
+		cfg.Sampler.Param = 1
+		closeTrace, err = tracing.InitGlobalTracer("hello", cfg, formats)
 	}
-	cfg.Sampler.Type = jaeger.SamplerTypeConst
-	cfg.Sampler.Param = 1
-	zipkinPropagator := zipkin.NewZipkinB3HTTPHeaderPropagator()
-	injector := jaegerCfg.Injector(opentracing.HTTPHeaders, zipkinPropagator)
-	extractor := jaegerCfg.Extractor(opentracing.HTTPHeaders, zipkinPropagator)
-	// Zipkin shares span ID between client and server spans; it must be enabled via the following option.
-	//zipkinSharedRPCSpan := jaegerCfg.ZipkinSharedRPCSpan(true)
-	closeTrace, err := cfg.InitGlobalTracer("hello",
-		injector, extractor,
-		//zipkinSharedRPCSpan,
-	)
 	if err != nil {
 		syslog.Fatalf("Init trace failed: \n%v", err)
 	}
@@ -60,6 +69,14 @@ func main() {
 	httpServer := http.NewServer(httpAddr)
 	httpServer.SetPathPrefix("")
 	httpServer.NotFound = http.JsonNotFoundHandler
+	// the tracer samples everything, the server decides what is kept
+	httpServer.SetSampling(http.SamplingOptions{
+		Rate:           0.1,
+		PerSecond:      100,
+		HonourUpstream: true,
+		Errors:         true,
+		SlowerThan:     time.Second,
+	})
 	go func() {
 		err := httpServer.Start()
 		if err != nil {
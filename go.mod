@@ -4,6 +4,8 @@ go 1.15
 
 require (
 	github.com/BurntSushi/toml v1.0.0 // indirect
+	github.com/alicebob/miniredis/v2 v2.23.1
+	github.com/andybalholm/brotli v1.0.4
 	github.com/codahale/hdrhistogram v0.9.0 // indirect
 	github.com/fasthttp/router v1.4.7
 	github.com/getsentry/sentry-go v0.13.0
@@ -13,16 +15,23 @@ require (
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/google/uuid v1.3.0
 	github.com/json-iterator/go v1.1.12
-	github.com/klauspost/compress v1.15.1 // indirect
+	github.com/klauspost/compress v1.15.1
 	github.com/mailru/easyjson v0.7.6
 	github.com/natefinch/lumberjack v2.0.0+incompatible
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/stretchr/testify v1.7.1 // indirect
 	github.com/uber/jaeger-client-go v2.30.0+incompatible
 	github.com/uber/jaeger-lib v2.2.0+incompatible // indirect
 	github.com/valyala/fasthttp v1.34.0
 	github.com/xeipuuv/gojsonschema v1.2.0
+	go.opentelemetry.io/contrib/propagators/b3 v1.0.0
+	go.opentelemetry.io/contrib/propagators/jaeger v1.0.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/bridge/opentracing v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	go.uber.org/zap v1.21.0
 	golang.org/x/tools v0.1.11-0.20220316014157-77aa08bb151a // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
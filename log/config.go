@@ -198,7 +198,7 @@ func formatDate(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 
 func updateScopes(options *Options, core zapcore.Core, errSink zapcore.WriteSyncer) error {
 	// init the global I/O funcs
-	writeFn.Store(core.Write)
+	setWriteFn(core.Write)
 	syncFn.Store(core.Sync)
 	errorSink.Store(errSink)
 
@@ -0,0 +1,181 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// WithRequestID returns a copy of ctx whose entries logged with Ctx carry id
+// as request_id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the request ID set with WithRequestID, "" when
+// unset.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ContextFieldsFunc returns the fields a context adds to log entries.
+type ContextFieldsFunc func(ctx context.Context) []zapcore.Field
+
+var (
+	contextFieldsLock sync.RWMutex
+	contextFieldsFns  []ContextFieldsFunc
+)
+
+// RegisterContextFields adds fn to the functions which describe a context
+// passed to Ctx or Scope.With. The tracing package registers one adding
+// trace_id and span_id of the span in the context.
+func RegisterContextFields(fn ContextFieldsFunc) {
+	contextFieldsLock.Lock()
+	defer contextFieldsLock.Unlock()
+	contextFieldsFns = append(contextFieldsFns, fn)
+}
+
+func contextFields(ctx context.Context) []zapcore.Field {
+	var fields []zapcore.Field
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+
+	contextFieldsLock.RLock()
+	defer contextFieldsLock.RUnlock()
+	for _, fn := range contextFieldsFns {
+		fields = append(fields, fn(ctx)...)
+	}
+	return fields
+}
+
+// ContextLogger logs to a scope with the fields of a context added to every
+// entry, and mirrors entries as logs of the span in the context when the
+// span log level of the scope allows.
+type ContextLogger struct {
+	scope *Scope
+	ctx   context.Context
+}
+
+// Ctx returns a logger of the default scope for ctx, e.g. the context
+// returned by GetTraceContext of server/http:
+//
+//	log.Ctx(http.GetTraceContext(ctx)).Info("order created", zap.String("id", id))
+func Ctx(ctx context.Context) *ContextLogger {
+	return defaultScope.With(ctx)
+}
+
+// With returns a logger of this scope for ctx.
+func (s *Scope) With(ctx context.Context) *ContextLogger {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &ContextLogger{scope: s, ctx: ctx}
+}
+
+// Error outputs a message at error level.
+func (l *ContextLogger) Error(msg string, fields ...zapcore.Field) {
+	if l.scope.GetOutputLevel() >= ErrorLevel || l.scope.GetSpanLogLevel() >= ErrorLevel {
+		l.emit(ErrorLevel, msg, fields)
+	}
+}
+
+// Errorf uses fmt.Sprintf to construct and log a message at error level.
+func (l *ContextLogger) Errorf(template string, args ...interface{}) {
+	if l.scope.GetOutputLevel() >= ErrorLevel || l.scope.GetSpanLogLevel() >= ErrorLevel {
+		l.emit(ErrorLevel, sprintf(template, args), nil)
+	}
+}
+
+// Warn outputs a message at warn level.
+func (l *ContextLogger) Warn(msg string, fields ...zapcore.Field) {
+	if l.scope.GetOutputLevel() >= WarnLevel || l.scope.GetSpanLogLevel() >= WarnLevel {
+		l.emit(WarnLevel, msg, fields)
+	}
+}
+
+// Warnf uses fmt.Sprintf to construct and log a message at warn level.
+func (l *ContextLogger) Warnf(template string, args ...interface{}) {
+	if l.scope.GetOutputLevel() >= WarnLevel || l.scope.GetSpanLogLevel() >= WarnLevel {
+		l.emit(WarnLevel, sprintf(template, args), nil)
+	}
+}
+
+// Info outputs a message at info level.
+func (l *ContextLogger) Info(msg string, fields ...zapcore.Field) {
+	if l.scope.GetOutputLevel() >= InfoLevel || l.scope.GetSpanLogLevel() >= InfoLevel {
+		l.emit(InfoLevel, msg, fields)
+	}
+}
+
+// Infof uses fmt.Sprintf to construct and log a message at info level.
+func (l *ContextLogger) Infof(template string, args ...interface{}) {
+	if l.scope.GetOutputLevel() >= InfoLevel || l.scope.GetSpanLogLevel() >= InfoLevel {
+		l.emit(InfoLevel, sprintf(template, args), nil)
+	}
+}
+
+// Debug outputs a message at debug level.
+func (l *ContextLogger) Debug(msg string, fields ...zapcore.Field) {
+	if l.scope.GetOutputLevel() >= DebugLevel || l.scope.GetSpanLogLevel() >= DebugLevel {
+		l.emit(DebugLevel, msg, fields)
+	}
+}
+
+// Debugf uses fmt.Sprintf to construct and log a message at debug level.
+func (l *ContextLogger) Debugf(template string, args ...interface{}) {
+	if l.scope.GetOutputLevel() >= DebugLevel || l.scope.GetSpanLogLevel() >= DebugLevel {
+		l.emit(DebugLevel, sprintf(template, args), nil)
+	}
+}
+
+func (l *ContextLogger) emit(level Level, msg string, fields []zapcore.Field) {
+	if l.scope.GetSpanLogLevel() >= level {
+		if sp := opentracing.SpanFromContext(l.ctx); sp != nil {
+			sp.LogFields(spanLogFields(levelToString[level], msg, fields)...)
+		}
+	}
+	if l.scope.GetOutputLevel() >= level {
+		fields = append(contextFields(l.ctx), fields...)
+		l.scope.emitSkip(1, levelToZap[level], l.scope.GetStackTraceLevel() >= level, msg, fields)
+	}
+}
+
+// spanLogFields converts a log entry to the fields of a span log.
+func spanLogFields(level, msg string, fields []zapcore.Field) []otlog.Field {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	out := make([]otlog.Field, 0, len(enc.Fields)+3)
+	out = append(out,
+		otlog.String("event", "log"),
+		otlog.String("level", level),
+		otlog.String("message", msg),
+	)
+	for k, v := range enc.Fields {
+		if s, ok := v.(string); ok {
+			out = append(out, otlog.String(k, s))
+		} else {
+			out = append(out, otlog.Object(k, v))
+		}
+	}
+	return out
+}
+
+func sprintf(template string, args []interface{}) string {
+	if len(args) > 0 {
+		return fmt.Sprintf(template, args...)
+	}
+	return template
+}
@@ -0,0 +1,70 @@
+package log_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/zhlls/go-common/log"
+	// registers the trace_id and span_id fields
+	_ "github.com/zhlls/go-common/tracing"
+)
+
+func observe(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	t.Cleanup(log.Observe(core))
+	return logs
+}
+
+func TestCtxFields(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	sp := tracer.StartSpan("test")
+	defer sp.Finish()
+	sc := sp.Context().(jaeger.SpanContext)
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want map[string]interface{}
+	}{
+		{"empty", context.Background(), map[string]interface{}{}},
+		{"request id", log.WithRequestID(context.Background(), "req-1"),
+			map[string]interface{}{"request_id": "req-1"}},
+		{"span", opentracing.ContextWithSpan(context.Background(), sp),
+			map[string]interface{}{"trace_id": sc.TraceID().String(), "span_id": sc.SpanID().String()}},
+		{"span and request id", log.WithRequestID(opentracing.ContextWithSpan(context.Background(), sp), "req-2"),
+			map[string]interface{}{"trace_id": sc.TraceID().String(), "span_id": sc.SpanID().String(), "request_id": "req-2"}},
+		// the request id is only read from the key set by WithRequestID
+		{"string key", context.WithValue(context.Background(), "__request_id__", "req-3"), map[string]interface{}{}},
+	} {
+		logs := observe(t)
+		log.Ctx(tc.ctx).Info("test")
+		entries := logs.All()
+		if len(entries) != 1 {
+			t.Fatalf("%s: got %d entries, want 1", tc.name, len(entries))
+		}
+		got := entries[0].ContextMap()
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: fields %v, want %v", tc.name, got, tc.want)
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%s: %s %v, want %v", tc.name, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	if id := log.RequestIDFromContext(context.Background()); id != "" {
+		t.Errorf("request id %q of an empty context", id)
+	}
+	if id := log.RequestIDFromContext(log.WithRequestID(context.Background(), "req-1")); id != "req-1" {
+		t.Errorf("request id %q, want req-1", id)
+	}
+}
@@ -0,0 +1,84 @@
+package log
+
+import (
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type observed struct {
+	core zapcore.Core
+}
+
+var (
+	observeMu sync.Mutex
+	// baseWrite is the write func set by Configure, observers are teed in
+	// front of it
+	baseWrite func(zapcore.Entry, []zapcore.Field) error
+	observers []*observed
+)
+
+// Observe tees every entry emitted through the logging subsystem into core,
+// in addition to the configured outputs. Calling the returned function
+// detaches core again, observers may be detached in any order. A later
+// call to Configure also detaches it.
+func Observe(core zapcore.Core) func() {
+	o := &observed{core: core}
+
+	observeMu.Lock()
+	if len(observers) == 0 {
+		baseWrite, _ = writeFn.Load().(func(zapcore.Entry, []zapcore.Field) error)
+	}
+	observers = append(observers, o)
+	storeWriteFn()
+	observeMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			observeMu.Lock()
+			defer observeMu.Unlock()
+			for i, other := range observers {
+				if other == o {
+					observers = append(observers[:i:i], observers[i+1:]...)
+					storeWriteFn()
+					return
+				}
+			}
+		})
+	}
+}
+
+// setWriteFn installs the write func of Configure, detaching all observers.
+func setWriteFn(write func(zapcore.Entry, []zapcore.Field) error) {
+	observeMu.Lock()
+	defer observeMu.Unlock()
+	observers = nil
+	baseWrite = write
+	writeFn.Store(write)
+}
+
+// storeWriteFn publishes baseWrite teed into the current observers, it must
+// be called with observeMu held.
+func storeWriteFn() {
+	base := baseWrite
+	if len(observers) == 0 {
+		writeFn.Store(base)
+		return
+	}
+	cores := make([]zapcore.Core, len(observers))
+	for i, o := range observers {
+		cores[i] = o.core
+	}
+	writeFn.Store(func(e zapcore.Entry, fields []zapcore.Field) error {
+		for _, core := range cores {
+			if core.Enabled(e.Level) {
+				_ = core.Write(e, fields)
+			}
+		}
+		if base == nil {
+			return nil
+		}
+		return base(e, fields)
+	})
+}
@@ -0,0 +1,53 @@
+package log
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestObserveDetachOutOfOrder(t *testing.T) {
+	first, firstLogs := observer.New(zapcore.DebugLevel)
+	second, secondLogs := observer.New(zapcore.DebugLevel)
+
+	detachFirst := Observe(first)
+	detachSecond := Observe(second)
+
+	Info("both")
+	detachFirst()
+	Info("second only")
+	detachSecond()
+	Info("none")
+
+	if got := messages(firstLogs); len(got) != 1 || got[0] != "both" {
+		t.Errorf("first observer: got %q, want [both]", got)
+	}
+	if got := messages(secondLogs); len(got) != 2 || got[0] != "both" || got[1] != "second only" {
+		t.Errorf("second observer: got %q, want [both second only]", got)
+	}
+}
+
+func TestObserveDetachTwice(t *testing.T) {
+	first, _ := observer.New(zapcore.DebugLevel)
+	second, secondLogs := observer.New(zapcore.DebugLevel)
+
+	detachFirst := Observe(first)
+	detachSecond := Observe(second)
+	defer detachSecond()
+	detachFirst()
+	detachFirst()
+
+	Info("kept")
+	if got := messages(secondLogs); len(got) != 1 {
+		t.Errorf("second observer: got %q, want [kept]", got)
+	}
+}
+
+func messages(logs *observer.ObservedLogs) []string {
+	var msgs []string
+	for _, e := range logs.All() {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
@@ -39,6 +39,7 @@ type Scope struct {
 	outputLevel     atomic.Value
 	stackTraceLevel atomic.Value
 	logCallers      atomic.Value
+	spanLogLevel    atomic.Value
 }
 
 var scopes = make(map[string]*Scope)
@@ -70,6 +71,7 @@ func RegisterScope(name string, description string, callerSkip int) *Scope {
 		s.SetOutputLevel(InfoLevel)
 		s.SetStackTraceLevel(NoneLevel)
 		s.SetLogCallers(false)
+		s.SetSpanLogLevel(NoneLevel)
 
 		if name != DefaultScopeName {
 			s.nameToEmit = name
@@ -236,6 +238,11 @@ func (s *Scope) Description() string {
 const callerSkipOffset = 2
 
 func (s *Scope) emit(level zapcore.Level, dumpStack bool, msg string, fields []zapcore.Field) {
+	s.emitSkip(1, level, dumpStack, msg, fields)
+}
+
+// emitSkip is emit for callers which are skip frames deeper.
+func (s *Scope) emitSkip(skip int, level zapcore.Level, dumpStack bool, msg string, fields []zapcore.Field) {
 	e := zapcore.Entry{
 		Message:    msg,
 		Level:      level,
@@ -244,7 +251,7 @@ func (s *Scope) emit(level zapcore.Level, dumpStack bool, msg string, fields []z
 	}
 
 	if s.GetLogCallers() {
-		e.Caller = zapcore.NewEntryCaller(runtime.Caller(s.callerSkip + callerSkipOffset))
+		e.Caller = zapcore.NewEntryCaller(runtime.Caller(s.callerSkip + callerSkipOffset + skip))
 	}
 
 	if dumpStack {
@@ -290,3 +297,15 @@ func (s *Scope) SetLogCallers(logCallers bool) {
 func (s *Scope) GetLogCallers() bool {
 	return s.logCallers.Load().(bool)
 }
+
+// SetSpanLogLevel adjusts up to which level entries logged with a context
+// are also added as logs to the span in the context. NoneLevel, the
+// default, disables it.
+func (s *Scope) SetSpanLogLevel(l Level) {
+	s.spanLogLevel.Store(l)
+}
+
+// GetSpanLogLevel returns the span log level associated with the scope.
+func (s *Scope) GetSpanLogLevel() Level {
+	return s.spanLogLevel.Load().(Level)
+}
@@ -19,6 +19,21 @@ var (
 	httpRequestTotal *prometheus.CounterVec
 	httpRequestBytes *prometheus.CounterVec
 
+	// http client metrics
+	httpClientRequestTotal    *prometheus.CounterVec
+	httpClientRequestDuration *prometheus.HistogramVec
+	httpClientInFlight        *prometheus.GaugeVec
+	httpClientRetryTotal      *prometheus.CounterVec
+	httpClientConnDuration    *prometheus.HistogramVec
+	httpClientHedgeTotal      *prometheus.CounterVec
+	httpClientHedgeWonTotal   *prometheus.CounterVec
+
+	// trace metrics
+	traceSpansTotal *prometheus.CounterVec
+
+	// circuit breaker metrics
+	circuitBreakerState *prometheus.GaugeVec
+
 	// grpc metrics
 	grpcSentBytes     prometheus.Counter
 	grpcReceivedBytes prometheus.Counter
@@ -74,6 +89,98 @@ func Init(namespace string) {
 		[]string{"method", "endpoint", "status"},
 	)
 
+	httpClientRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "request_total",
+			Help:      "Total Number of Outbound HTTP Requests.",
+		},
+		[]string{"host", "method", "route", "status"},
+	)
+
+	httpClientRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "request_duration_seconds",
+			Help:      "Time of Outbound HTTP Requests until the Response Body is Closed in Seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"host", "method", "route", "status"},
+	)
+
+	httpClientInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "in_flight_requests",
+			Help:      "Number of Outbound HTTP Requests in Flight.",
+		},
+		[]string{"host"},
+	)
+
+	httpClientRetryTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "retry_total",
+			Help:      "Total Number of Retried Outbound HTTP Requests.",
+		},
+		[]string{"host", "method", "route"},
+	)
+
+	httpClientConnDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "connection_phase_seconds",
+			Help:      "Time of the DNS, Connect and TLS Phases of New Outbound Connections in Seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"host", "phase"},
+	)
+
+	httpClientHedgeTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "hedge_total",
+			Help:      "Total Number of Hedged Outbound HTTP Requests Issued.",
+		},
+		[]string{"host", "method", "route"},
+	)
+
+	httpClientHedgeWonTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "hedge_won_total",
+			Help:      "Total Number of Hedged Outbound HTTP Requests Answered First.",
+		},
+		[]string{"host", "method", "route"},
+	)
+
+	traceSpansTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "trace",
+			Name:      "spans_total",
+			Help:      "Total Number of Server Spans by Sampling Decision and Reason.",
+		},
+		[]string{"decision", "reason"},
+	)
+
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "state",
+			Help:      "State of Each Circuit Breaker, 0 closed, 1 open, 2 half-open.",
+		},
+		[]string{"name"},
+	)
+
 	grpcSentBytes = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Namespace: namespace,
@@ -96,6 +203,15 @@ func Init(namespace string) {
 		httpResponseSize,
 		httpRequestTotal,
 		httpRequestBytes,
+		httpClientRequestTotal,
+		httpClientRequestDuration,
+		httpClientInFlight,
+		httpClientRetryTotal,
+		httpClientConnDuration,
+		httpClientHedgeTotal,
+		httpClientHedgeWonTotal,
+		traceSpansTotal,
+		circuitBreakerState,
 		grpcSentBytes,
 		grpcReceivedBytes,
 	)
@@ -137,6 +253,63 @@ func CollectAPIRequestBytes(method, endpoint, status string, value float64) {
 	}
 }
 
+// CollectHTTPClientRequest collect an outbound request and its duration
+func CollectHTTPClientRequest(host, method, route, status string, seconds float64) {
+	if inited {
+		httpClientRequestTotal.WithLabelValues(host, method, route, status).Inc()
+		httpClientRequestDuration.WithLabelValues(host, method, route, status).Observe(seconds)
+	}
+}
+
+// CollectHTTPClientInFlight collect a change of the outbound requests in flight
+func CollectHTTPClientInFlight(host string, delta float64) {
+	if inited {
+		httpClientInFlight.WithLabelValues(host).Add(delta)
+	}
+}
+
+// CollectHTTPClientRetry collect a retry of an outbound request
+func CollectHTTPClientRetry(host, method, route string) {
+	if inited {
+		httpClientRetryTotal.WithLabelValues(host, method, route).Inc()
+	}
+}
+
+// CollectHTTPClientConnPhase collect the duration of a connection phase, dns, connect or tls
+func CollectHTTPClientConnPhase(host, phase string, seconds float64) {
+	if inited {
+		httpClientConnDuration.WithLabelValues(host, phase).Observe(seconds)
+	}
+}
+
+// CollectHTTPClientHedge collect a hedge of an outbound request
+func CollectHTTPClientHedge(host, method, route string) {
+	if inited {
+		httpClientHedgeTotal.WithLabelValues(host, method, route).Inc()
+	}
+}
+
+// CollectHTTPClientHedgeWon collect a hedge which answered before the original request
+func CollectHTTPClientHedgeWon(host, method, route string) {
+	if inited {
+		httpClientHedgeWonTotal.WithLabelValues(host, method, route).Inc()
+	}
+}
+
+// CollectTraceSpan collect the sampling decision of a server span
+func CollectTraceSpan(decision, reason string) {
+	if inited {
+		traceSpansTotal.WithLabelValues(decision, reason).Inc()
+	}
+}
+
+// CollectCircuitBreakerState collect the state of a circuit breaker
+func CollectCircuitBreakerState(name string, state float64) {
+	if inited {
+		circuitBreakerState.WithLabelValues(name).Set(state)
+	}
+}
+
 func CollectGRPCSentBytes(value float64) {
 	if inited {
 		grpcSentBytes.Add(value)
@@ -0,0 +1,339 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLockTTL        = 30 * time.Second
+	defaultLockRetryDelay = 50 * time.Millisecond
+	defaultLockMaxDelay   = time.Second
+)
+
+var (
+	// ErrLockNotObtained is returned by TryObtain when another holder has
+	// the lock.
+	ErrLockNotObtained = errors.New("redis: lock not obtained")
+
+	// ErrLockNotHeld is returned when the lease of a lock expired and it
+	// may be held by someone else.
+	ErrLockNotHeld = errors.New("redis: lock not held")
+)
+
+// acquireScript sets the lock key when it is free and returns the next
+// fencing token, nil when the lock is taken.
+var acquireScript = redis.NewScript(`
+if redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("incr", KEYS[2])
+end
+return false
+`)
+
+// releaseScript deletes the lock key when it still holds our token.
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// extendScript renews the lease when the lock key still holds our token.
+var extendScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// LockOptions configures a Lock.
+type LockOptions struct {
+	// TTL is the lease of the lock, after which it is free again when the
+	// holder died, 30s by default.
+	TTL time.Duration
+
+	// ExtendInterval renews the lease while the lock is held, TTL/3 by
+	// default. Negative disables the renewal. Intervals of 0.9*TTL and
+	// longer, which would renew the lease after Done is closed, are
+	// replaced by the default.
+	ExtendInterval time.Duration
+
+	// RetryDelay is the backoff of Obtain before the second attempt,
+	// doubled with every further attempt and randomized with full jitter,
+	// 50ms by default.
+	RetryDelay time.Duration
+
+	// MaxRetryDelay caps the backoff of Obtain, 1s by default.
+	MaxRetryDelay time.Duration
+}
+
+func (o LockOptions) withDefaults() LockOptions {
+	if o.TTL <= 0 {
+		o.TTL = defaultLockTTL
+	}
+	if o.ExtendInterval == 0 || o.ExtendInterval >= o.TTL-o.TTL/10 {
+		o.ExtendInterval = o.TTL / 3
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = defaultLockRetryDelay
+	}
+	if o.MaxRetryDelay <= 0 {
+		o.MaxRetryDelay = defaultLockMaxDelay
+	}
+	return o
+}
+
+// backoff returns the full jitter delay before attempt number attempt+1.
+func (o LockOptions) backoff(attempt int) time.Duration {
+	ceiling := o.MaxRetryDelay
+	if shift := uint(attempt - 1); shift < 32 {
+		if d := o.RetryDelay << shift; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	return time.Duration(mrand.Int63n(int64(ceiling) + 1))
+}
+
+// Lock is a distributed lock held by one holder at a time:
+//
+//	l, err := redis.Obtain(ctx, "report", redis.LockOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	defer l.Release(context.Background())
+//	// work until done, stop early when l.Done() is closed and pass
+//	// l.Fence() along with writes to downstream stores
+//
+// The lock is stored under "lock:{<name>}" and the fencing counter under
+// "lock:{<name>}:fence", in the same cluster slot.
+type Lock struct {
+	store *Store
+	name  string
+	key   string
+	token string
+	fence int64
+	ttl   time.Duration
+
+	// expiry closes done ahead of the end of the lease, it is reset by
+	// every renewal
+	mu       sync.Mutex
+	expiry   *time.Timer
+	done     chan struct{}
+	doneOnce sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// Obtain obtains the lock name, retrying with backoff until it is free or
+// ctx is done. ctx only bounds the acquisition, the lock is held until
+// Release or until its lease is lost.
+func Obtain(ctx context.Context, name string, opts LockOptions) (*Lock, error) {
+	return defaultStore.Obtain(ctx, name, opts)
+}
+
+// TryObtain obtains the lock name with a single attempt, it returns
+// ErrLockNotObtained when the lock is held.
+func TryObtain(ctx context.Context, name string, opts LockOptions) (*Lock, error) {
+	return defaultStore.TryObtain(ctx, name, opts)
+}
+
+// Obtain obtains the lock name, retrying with backoff until it is free or
+// ctx is done.
+func (s *Store) Obtain(ctx context.Context, name string, opts LockOptions) (*Lock, error) {
+	opts = opts.withDefaults()
+	for attempt := 1; ; attempt++ {
+		l, err := s.TryObtain(ctx, name, opts)
+		if err != ErrLockNotObtained {
+			return l, err
+		}
+		timer := time.NewTimer(opts.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// TryObtain obtains the lock name with a single attempt.
+func (s *Store) TryObtain(ctx context.Context, name string, opts LockOptions) (*Lock, error) {
+	opts = opts.withDefaults()
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key := "lock:{" + name + "}"
+
+	now := time.Now()
+	fence, err := acquireScript.Run(ctx, s.client, []string{key, key + ":fence"},
+		token, opts.TTL.Milliseconds()).Int64()
+	s.metrics.observe("lock", key, now, err)
+	if err == redis.Nil {
+		return nil, ErrLockNotObtained
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lock{
+		store:   s,
+		name:    name,
+		key:     key,
+		token:   token,
+		fence:   fence,
+		ttl:     opts.TTL,
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	l.mu.Lock()
+	l.expiry = time.AfterFunc(untilExpiry(now, opts.TTL), l.expire)
+	l.mu.Unlock()
+	if opts.ExtendInterval > 0 {
+		go l.keepAlive(opts.ExtendInterval)
+	} else {
+		close(l.stopped)
+	}
+	return l, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Name returns the name of the lock.
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Token returns the random token identifying this holder.
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Fence returns the fencing token of this holder, which grows with every
+// acquisition of the lock. Downstream stores reject writes carrying a
+// smaller fence than one they have seen, so a holder which lost its lease
+// without noticing cannot overwrite the work of the next one.
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Done is closed when the lock is released or its lease was lost, and a
+// tenth of the TTL before the lease expires unless it was renewed by then.
+func (l *Lock) Done() <-chan struct{} {
+	return l.done
+}
+
+// Extend renews the lease to ttl. It returns ErrLockNotHeld when the lease
+// was lost, which also closes Done.
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	now := time.Now()
+	n, err := extendScript.Run(ctx, l.store.client, []string{l.key}, l.token, ttl.Milliseconds()).Int64()
+	l.store.metrics.observe("extend", l.key, now, err)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		l.lose()
+		return ErrLockNotHeld
+	}
+	l.mu.Lock()
+	if l.expiry != nil {
+		// the lease runs from the call, not from the reply
+		l.expiry.Reset(untilExpiry(now, ttl))
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// untilExpiry returns the time until Done is closed for a lease of ttl
+// requested at start, leaving a tenth of it for timers and clock drift.
+func untilExpiry(start time.Time, ttl time.Duration) time.Duration {
+	return time.Until(start.Add(ttl - ttl/10))
+}
+
+// Release stops the lease renewal and frees the lock when it is still held
+// by l, otherwise it returns ErrLockNotHeld.
+func (l *Lock) Release(ctx context.Context) error {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+	<-l.stopped
+	defer l.lose()
+
+	now := time.Now()
+	n, err := releaseScript.Run(ctx, l.store.client, []string{l.key}, l.token).Int64()
+	l.store.metrics.observe("unlock", l.key, now, err)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *Lock) lose() {
+	l.doneOnce.Do(func() {
+		l.mu.Lock()
+		if l.expiry != nil {
+			l.expiry.Stop()
+			l.expiry = nil
+		}
+		l.mu.Unlock()
+		close(l.done)
+	})
+}
+
+func (l *Lock) expire() {
+	l.store.logger.Warn("redis lock lost, lease not renewed", zap.String("lock", l.name))
+	l.lose()
+}
+
+// keepAlive renews the lease every interval until Release. The lock is
+// given up when it was taken over, or by the expiry timer when it could not
+// be renewed in time.
+func (l *Lock) keepAlive(interval time.Duration) {
+	defer close(l.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := l.Extend(ctx, l.ttl)
+		cancel()
+		switch {
+		case err == nil:
+		case err == ErrLockNotHeld:
+			l.store.logger.Warn("redis lock lost", zap.String("lock", l.name))
+			return
+		default:
+			l.store.logger.Warn("renew redis lock failed",
+				zap.String("lock", l.name),
+				zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	s, err := NewStore(Options{
+		Endpoints:   []string{mr.Addr()},
+		ClusterMode: ClusterModeSingle,
+		Registerer:  prometheus.NewRegistry(),
+		MaxRetries:  -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, mr
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestLockObtainRelease(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+
+	l, err := s.TryObtain(ctx, "job", LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Fence() != 1 {
+		t.Errorf("fence %d, want 1", l.Fence())
+	}
+	if got, _ := mr.Get("lock:{job}"); got != l.Token() {
+		t.Errorf("lock key holds %q, want token %q", got, l.Token())
+	}
+	if _, err := s.TryObtain(ctx, "job", LockOptions{}); err != ErrLockNotObtained {
+		t.Errorf("second TryObtain: %v, want ErrLockNotObtained", err)
+	}
+
+	if err := l.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !isClosed(l.Done()) {
+		t.Error("Done open after Release")
+	}
+	if mr.Exists("lock:{job}") {
+		t.Error("lock key left after Release")
+	}
+
+	next, err := s.Obtain(ctx, "job", LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Release(ctx)
+	if next.Fence() != 2 {
+		t.Errorf("fence %d after release, want 2", next.Fence())
+	}
+}
+
+func TestLockExtend(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+
+	l, err := s.TryObtain(ctx, "job", LockOptions{TTL: 200 * time.Millisecond, ExtendInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release(ctx)
+	if err := l.Extend(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("lock:{job}"); ttl != time.Minute {
+		t.Errorf("lease %v, want 1m", ttl)
+	}
+	// the renewal moved the expiry of Done along
+	time.Sleep(300 * time.Millisecond)
+	if isClosed(l.Done()) {
+		t.Error("Done closed after the lease was extended")
+	}
+}
+
+func TestLockLostWhenTakenOver(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+
+	l, err := s.TryObtain(ctx, "job", LockOptions{ExtendInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mr.Set("lock:{job}", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Extend(ctx, time.Minute); err != ErrLockNotHeld {
+		t.Errorf("Extend: %v, want ErrLockNotHeld", err)
+	}
+	if !isClosed(l.Done()) {
+		t.Error("Done open after the lock was taken over")
+	}
+	if err := l.Release(ctx); err != ErrLockNotHeld {
+		t.Errorf("Release: %v, want ErrLockNotHeld", err)
+	}
+	if got, _ := mr.Get("lock:{job}"); got != "other" {
+		t.Errorf("Release removed the lock of the new holder, key holds %q", got)
+	}
+}
+
+func TestLockKeepAlive(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+
+	l, err := s.TryObtain(ctx, "job", LockOptions{TTL: 200 * time.Millisecond, ExtendInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release(ctx)
+	time.Sleep(500 * time.Millisecond)
+	if isClosed(l.Done()) {
+		t.Error("Done closed while the lease was renewed")
+	}
+}
+
+func TestLockDoneBeforeLeaseExpires(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	const ttl = 300 * time.Millisecond
+
+	start := time.Now()
+	l, err := s.TryObtain(ctx, "job", LockOptions{TTL: ttl, ExtendInterval: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renewals fail from now on
+	mr.Close()
+
+	select {
+	case <-l.Done():
+		if elapsed := time.Since(start); elapsed >= ttl {
+			t.Errorf("Done closed after %v, past the lease of %v", elapsed, ttl)
+		}
+	case <-time.After(2 * ttl):
+		t.Fatal("Done not closed after the lease expired")
+	}
+}
+
+func TestLockExtendIntervalClamped(t *testing.T) {
+	for _, tc := range []struct {
+		opts LockOptions
+		want time.Duration
+	}{
+		{LockOptions{TTL: 30 * time.Second}, 10 * time.Second},
+		{LockOptions{TTL: 30 * time.Second, ExtendInterval: 20 * time.Second}, 20 * time.Second},
+		{LockOptions{TTL: 30 * time.Second, ExtendInterval: 27 * time.Second}, 10 * time.Second},
+		{LockOptions{TTL: 30 * time.Second, ExtendInterval: time.Minute}, 10 * time.Second},
+		{LockOptions{TTL: 30 * time.Second, ExtendInterval: -1}, -1},
+	} {
+		if got := tc.opts.withDefaults().ExtendInterval; got != tc.want {
+			t.Errorf("%+v: ExtendInterval %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	l, err := s.TryObtain(ctx, "job", LockOptions{TTL: 200 * time.Millisecond, ExtendInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release(ctx)
+	time.Sleep(500 * time.Millisecond)
+	if isClosed(l.Done()) {
+		t.Error("Done closed before the lease was renewed")
+	}
+}
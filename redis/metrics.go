@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// opBuckets suit Redis, which mostly answers within a millisecond.
+var opBuckets = []float64{0.0005, 0.001, 0.002, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// KeyPrefix is the default Options.KeyPattern. It returns the part of key
+// before the first ":", e.g. "user" for "user:42", or key itself when it
+// has none.
+func KeyPrefix(key string) string {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// poolsMu guards the registration of pool collectors and their clients.
+var poolsMu sync.Mutex
+
+type storeMetrics struct {
+	keyPattern func(string) string
+	opLatency  *prometheus.HistogramVec
+	opErrors   *prometheus.CounterVec
+	pool       *poolCollector
+	client     redis.UniversalClient
+	registerer prometheus.Registerer
+}
+
+// newStoreMetrics registers the metrics of a store. Stores of the same
+// name share the op metrics and the pool stats, which add up the pools of
+// all open stores of the name.
+func newStoreMetrics(opts Options, client redis.UniversalClient) (*storeMetrics, error) {
+	labels := prometheus.Labels{"store": opts.Name}
+	m := &storeMetrics{
+		keyPattern: opts.KeyPattern,
+		opLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "redis_op_latency_seconds",
+			Help:        "The latency of redis operations.",
+			Buckets:     opBuckets,
+			ConstLabels: labels,
+		}, []string{"op", "key"}),
+		opErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "redis_op_errors_total",
+			Help:        "The number of failed redis operations.",
+			ConstLabels: labels,
+		}, []string{"op"}),
+		registerer: opts.Registerer,
+	}
+
+	latency, err := registerOrExisting(opts.Registerer, m.opLatency)
+	if err != nil {
+		return nil, err
+	}
+	m.opLatency = latency.(*prometheus.HistogramVec)
+	errs, err := registerOrExisting(opts.Registerer, m.opErrors)
+	if err != nil {
+		return nil, err
+	}
+	m.opErrors = errs.(*prometheus.CounterVec)
+
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	pool, err := registerOrExisting(opts.Registerer, newPoolCollector(opts.Namespace, labels))
+	if err != nil {
+		return nil, err
+	}
+	m.pool, m.client = pool.(*poolCollector), client
+	m.pool.mu.Lock()
+	m.pool.clients = append(m.pool.clients, client)
+	m.pool.mu.Unlock()
+	return m, nil
+}
+
+// registerOrExisting registers c, or returns the collector registered
+// before by a store of the same name.
+func registerOrExisting(r prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, err
+		}
+		return are.ExistingCollector, nil
+	}
+	return c, nil
+}
+
+// observe records an op on key which took since start and failed with err.
+func (m *storeMetrics) observe(op, key string, start time.Time, err error) {
+	m.opLatency.WithLabelValues(op, m.keyPattern(key)).Observe(time.Since(start).Seconds())
+	if err != nil && err != redis.Nil {
+		m.opErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// unregister removes the pool of a closed store from the pool stats, which
+// are unregistered with the last store of the name.
+func (m *storeMetrics) unregister() {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	c := m.pool
+	c.mu.Lock()
+	for i, client := range c.clients {
+		if client == m.client {
+			c.clients = append(c.clients[:i:i], c.clients[i+1:]...)
+			break
+		}
+	}
+	last := len(c.clients) == 0
+	c.mu.Unlock()
+	if last {
+		m.registerer.Unregister(c)
+	}
+}
+
+// poolCollector exports the sum of the PoolStats of the clients of a store
+// name when scraped.
+type poolCollector struct {
+	mu      sync.Mutex
+	clients []redis.UniversalClient
+
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	staleConns *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+}
+
+func newPoolCollector(namespace string, labels prometheus.Labels) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis_pool", name), help, nil, labels)
+	}
+	return &poolCollector{
+		hits:       desc("hits_total", "The number of times a free connection was found in the pool."),
+		misses:     desc("misses_total", "The number of times a free connection was not found in the pool."),
+		timeouts:   desc("timeouts_total", "The number of times a wait for a connection timed out."),
+		staleConns: desc("stale_conns_total", "The number of stale connections removed from the pool."),
+		totalConns: desc("conns", "The number of connections in the pool."),
+		idleConns:  desc("idle_conns", "The number of idle connections in the pool."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.staleConns
+	ch <- c.totalConns
+	ch <- c.idleConns
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	var stats redis.PoolStats
+	c.mu.Lock()
+	for _, client := range c.clients {
+		s := client.PoolStats()
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+		stats.Timeouts += s.Timeouts
+		stats.StaleConns += s.StaleConns
+		stats.TotalConns += s.TotalConns
+		stats.IdleConns += s.IdleConns
+	}
+	c.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.staleConns, prometheus.CounterValue, float64(stats.StaleConns))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+}
@@ -0,0 +1,155 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newMetricsStore(t *testing.T, mr *miniredis.Miniredis, reg prometheus.Registerer, opts Options) *Store {
+	opts.Endpoints = []string{mr.Addr()}
+	opts.ClusterMode = ClusterModeSingle
+	opts.Registerer = reg
+	opts.MaxRetries = -1
+	s, err := NewStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// findMetric returns the metric name of reg whose labels include labels.
+func findMetric(t *testing.T, reg prometheus.Gatherer, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for k, v := range labels {
+				found := false
+				for _, l := range m.GetLabel() {
+					if l.GetName() == k && l.GetValue() == v {
+						found = true
+					}
+				}
+				if !found {
+					continue metrics
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+func TestMetricsKeyPattern(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	reg := prometheus.NewRegistry()
+	s := newMetricsStore(t, mr, reg, Options{Name: "cache"})
+	defer s.Close()
+	custom := newMetricsStore(t, mr, reg, Options{
+		Name:       "sessions",
+		KeyPattern: func(key string) string { return strings.SplitN(key, "/", 2)[0] },
+	})
+	defer custom.Close()
+
+	for _, key := range []string{"user:1", "user:2", "plain"} {
+		_, _ = s.Get(key)
+	}
+	_, _ = custom.Get("session/abc")
+
+	for _, tc := range []struct {
+		store, key string
+		count      uint64
+	}{
+		{"cache", "user", 2},
+		{"cache", "plain", 1},
+		{"sessions", "session", 1},
+	} {
+		m := findMetric(t, reg, "notifier_redis_op_latency_seconds",
+			map[string]string{"store": tc.store, "op": "get", "key": tc.key})
+		if m == nil || m.GetHistogram().GetSampleCount() != tc.count {
+			t.Errorf("%s %s: latency %v, want %d observations", tc.store, tc.key, m, tc.count)
+		}
+	}
+}
+
+func TestMetricsErrors(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	reg := prometheus.NewRegistry()
+	s := newMetricsStore(t, mr, reg, Options{Name: "cache"})
+	defer s.Close()
+
+	// a missing key is no error
+	if _, err := s.Get("user:missing"); err != redis.Nil {
+		t.Fatalf("get missing key: %v", err)
+	}
+	if m := findMetric(t, reg, "notifier_redis_op_errors_total", map[string]string{"op": "get"}); m != nil {
+		t.Errorf("missing key counted as error: %v", m)
+	}
+
+	mr.SetError("READONLY")
+	_ = s.Set("user:1", "a", 60)
+	_ = s.Set("user:1", "a", 60)
+	mr.SetError("")
+	m := findMetric(t, reg, "notifier_redis_op_errors_total", map[string]string{"store": "cache", "op": "set"})
+	if m == nil || m.GetCounter().GetValue() != 2 {
+		t.Errorf("set errors %v, want 2", m)
+	}
+}
+
+func TestMetricsPoolOfStoresWithSameName(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	reg := prometheus.NewRegistry()
+	conns := func() float64 {
+		m := findMetric(t, reg, "notifier_redis_pool_conns", map[string]string{"store": "cache"})
+		if m == nil {
+			return -1
+		}
+		return m.GetGauge().GetValue()
+	}
+
+	first := newMetricsStore(t, mr, reg, Options{Name: "cache"})
+	second := newMetricsStore(t, mr, reg, Options{Name: "cache"})
+	if got := conns(); got != 2 {
+		t.Errorf("conns %v, want the pools of both stores", got)
+	}
+
+	_ = first.Close()
+	if got := conns(); got != 1 {
+		t.Errorf("conns %v after closing the first store, want the pool of the second", got)
+	}
+	_ = second.Close()
+	if got := conns(); got != -1 {
+		t.Errorf("conns %v after closing both stores, want no pool stats", got)
+	}
+
+	// a store of the name opened later exports its pool again
+	third := newMetricsStore(t, mr, reg, Options{Name: "cache"})
+	defer third.Close()
+	if got := conns(); got != 1 {
+		t.Errorf("conns %v of a reopened store, want 1", got)
+	}
+}
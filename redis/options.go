@@ -0,0 +1,428 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zhlls/go-common/log"
+)
+
+// Options configures a Store. Zero values take the go-redis defaults, for
+// the timeouts, MaxRetries and IdleTimeout -1 disables them.
+type Options struct {
+	// Name tells several stores apart in metrics and logs, e.g. "cache" and
+	// "queue", DefaultName by default.
+	Name string
+
+	// Registerer registers the metrics of the store,
+	// prometheus.DefaultRegisterer by default.
+	Registerer prometheus.Registerer
+
+	// Logger logs the messages of the store, the "redis" scope by default.
+	Logger *log.Scope
+
+	// Namespace prefixes the metric names, MetricNamespace by default.
+	Namespace string
+
+	// KeyPattern maps keys to the key label of metrics and traces, which
+	// must take a bounded number of values, KeyPrefix by default.
+	KeyPattern func(key string) string
+
+	// Endpoints are host:port addresses: the server in ClusterModeSingle,
+	// seed nodes in ClusterModeCluster and the sentinels in
+	// ClusterModeSentinel.
+	Endpoints          []string
+	Username           string
+	Password           string
+	ClusterMode        string
+	SentinelMasterName string
+	DB                 int
+	PoolSize           int
+	MinIdleConns       int
+
+	// TLS enables TLS, implied by the other TLS options. TLSCAFile verifies
+	// the server instead of the system roots, TLSCertFile and TLSKeyFile
+	// present a client certificate.
+	TLS                   bool
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// PoolTimeout is how long to wait for a free connection when all are
+	// busy.
+	PoolTimeout time.Duration
+
+	// MaxRetries retries failed commands, with a backoff between
+	// MinRetryBackoff and MaxRetryBackoff.
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+
+	// IdleTimeout closes connections idle for longer, checked every
+	// IdleCheckFrequency. MaxConnAge closes connections older than it.
+	IdleTimeout        time.Duration
+	IdleCheckFrequency time.Duration
+	MaxConnAge         time.Duration
+
+	// ReadOnly sends read-only commands to replicas in ClusterModeCluster.
+	// In ClusterModeSentinel all commands go to replicas, so writes fail.
+	ReadOnly bool
+
+	// RouteByLatency sends read-only commands to the node with the lowest
+	// latency, the master or a replica. In ClusterModeSentinel it requires
+	// DB 0.
+	RouteByLatency bool
+}
+
+// ParseURL parses Options from a URL:
+//
+//	redis://[user:password@]host[:port][,host[:port]...][/db][?option=value...]
+//
+// rediss:// enables TLS. Several hosts, mode=cluster or mode=sentinel with
+// master=<name> select the mode, Single by default. Other options are
+// named like the fields in snake case, e.g. dial_timeout=3s, pool_size=20,
+// route_by_latency=true or tls_ca_file=/etc/redis/ca.pem. Hosts without a
+// port default to 26379 in sentinel mode and to 6379 otherwise.
+func ParseURL(rawurl string) (Options, error) {
+	var opts Options
+	rawurl, hosts := splitHosts(rawurl)
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return opts, fmt.Errorf("redis: parse url: %w", err)
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		opts.TLS = true
+	default:
+		return opts, fmt.Errorf("redis: invalid url scheme %q, want redis or rediss", u.Scheme)
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			opts.Password = password
+		} else {
+			// redis://password@host like redis-cli
+			opts.Username, opts.Password = "", opts.Username
+		}
+	}
+	for _, host := range hosts {
+		if host == "" {
+			continue
+		}
+		// url.Parse checks each host on its own, a list fails when only
+		// some hosts carry a port
+		hu, err := url.Parse("redis://" + host)
+		if err != nil || hu.Host != host {
+			return opts, fmt.Errorf("redis: invalid host %q in url", host)
+		}
+		opts.Endpoints = append(opts.Endpoints, host)
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return opts, fmt.Errorf("redis: invalid db %q in url path", db)
+		}
+	}
+
+	opts.ClusterMode = ClusterModeSingle
+	if len(opts.Endpoints) > 1 {
+		opts.ClusterMode = ClusterModeCluster
+	}
+	q := u.Query()
+	p := queryParser{q: q}
+	switch mode := p.string("mode"); strings.ToLower(mode) {
+	case "":
+	case "single":
+		opts.ClusterMode = ClusterModeSingle
+	case "cluster":
+		opts.ClusterMode = ClusterModeCluster
+	case "sentinel":
+		opts.ClusterMode = ClusterModeSentinel
+	default:
+		return opts, fmt.Errorf("redis: invalid mode %q in url, want single, cluster or sentinel", mode)
+	}
+	port := "6379"
+	if opts.ClusterMode == ClusterModeSentinel {
+		port = "26379"
+	}
+	for i, host := range opts.Endpoints {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			opts.Endpoints[i] = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+	}
+	opts.SentinelMasterName = p.string("master")
+	opts.Name = p.string("name")
+	opts.PoolSize = p.int("pool_size")
+	opts.MinIdleConns = p.int("min_idle_conns")
+	opts.TLSCAFile = p.string("tls_ca_file")
+	opts.TLSCertFile = p.string("tls_cert_file")
+	opts.TLSKeyFile = p.string("tls_key_file")
+	opts.TLSServerName = p.string("tls_server_name")
+	opts.TLSInsecureSkipVerify = p.bool("tls_insecure_skip_verify")
+	opts.DialTimeout = p.duration("dial_timeout")
+	opts.ReadTimeout = p.duration("read_timeout")
+	opts.WriteTimeout = p.duration("write_timeout")
+	opts.PoolTimeout = p.duration("pool_timeout")
+	opts.MaxRetries = p.int("max_retries")
+	opts.MinRetryBackoff = p.duration("min_retry_backoff")
+	opts.MaxRetryBackoff = p.duration("max_retry_backoff")
+	opts.IdleTimeout = p.duration("idle_timeout")
+	opts.IdleCheckFrequency = p.duration("idle_check_frequency")
+	opts.MaxConnAge = p.duration("max_conn_age")
+	opts.ReadOnly = p.bool("read_only")
+	opts.RouteByLatency = p.bool("route_by_latency")
+	if p.err != nil {
+		return opts, p.err
+	}
+	for name := range q {
+		return opts, fmt.Errorf("redis: unknown option %q in url", name)
+	}
+	return opts, opts.Validate()
+}
+
+// splitHosts takes the comma separated hosts out of the authority of rawurl.
+func splitHosts(rawurl string) (string, []string) {
+	i := strings.Index(rawurl, "://")
+	if i < 0 {
+		return rawurl, nil
+	}
+	start := i + len("://")
+	end := strings.IndexAny(rawurl[start:], "/?#")
+	if end < 0 {
+		end = len(rawurl)
+	} else {
+		end += start
+	}
+	if at := strings.LastIndex(rawurl[start:end], "@"); at >= 0 {
+		start += at + 1
+	}
+	return rawurl[:start] + rawurl[end:], strings.Split(rawurl[start:end], ",")
+}
+
+// queryParser reads the options of a URL, keeping the first error.
+type queryParser struct {
+	q   url.Values
+	err error
+}
+
+func (p *queryParser) string(name string) string {
+	v := p.q.Get(name)
+	p.q.Del(name)
+	return v
+}
+
+func (p *queryParser) int(name string) int {
+	v := p.string(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("redis: invalid %s %q in url, want an integer", name, v)
+	}
+	return n
+}
+
+func (p *queryParser) bool(name string) bool {
+	v := p.string(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("redis: invalid %s %q in url, want true or false", name, v)
+	}
+	return b
+}
+
+func (p *queryParser) duration(name string) time.Duration {
+	v := p.string(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("redis: invalid %s %q in url, want a duration like 3s", name, v)
+	}
+	return d
+}
+
+// Validate checks that opts describe a usable deployment.
+func (opts Options) Validate() error {
+	if len(opts.Endpoints) == 0 {
+		return errors.New("redis: no endpoints")
+	}
+	for _, ep := range opts.Endpoints {
+		if _, _, err := net.SplitHostPort(ep); err != nil {
+			return fmt.Errorf("redis: invalid endpoint %q, want host:port", ep)
+		}
+	}
+	switch opts.ClusterMode {
+	case ClusterModeSingle:
+		if len(opts.Endpoints) > 1 {
+			return fmt.Errorf("redis: %d endpoints in %s mode, want one", len(opts.Endpoints), ClusterModeSingle)
+		}
+		if opts.ReadOnly || opts.RouteByLatency {
+			return fmt.Errorf("redis: ReadOnly and RouteByLatency need %s or %s mode", ClusterModeCluster, ClusterModeSentinel)
+		}
+	case ClusterModeCluster:
+		if opts.DB != 0 {
+			return fmt.Errorf("redis: db %d in %s mode, which only has db 0", opts.DB, ClusterModeCluster)
+		}
+	case ClusterModeSentinel:
+		if opts.SentinelMasterName == "" {
+			return errors.New("redis: no sentinel master name")
+		}
+		if opts.RouteByLatency && opts.DB != 0 {
+			return fmt.Errorf("redis: db %d with RouteByLatency in %s mode, which only supports db 0", opts.DB, ClusterModeSentinel)
+		}
+	default:
+		return fmt.Errorf("redis: invalid mode %q, want %s, %s or %s",
+			opts.ClusterMode, ClusterModeSingle, ClusterModeCluster, ClusterModeSentinel)
+	}
+	if opts.DB < 0 {
+		return fmt.Errorf("redis: invalid db %d", opts.DB)
+	}
+	if opts.Username != "" && opts.Password == "" {
+		return errors.New("redis: username without password")
+	}
+	if opts.PoolSize < 0 || opts.MinIdleConns < 0 {
+		return errors.New("redis: negative PoolSize or MinIdleConns")
+	}
+	if opts.PoolSize > 0 && opts.MinIdleConns > opts.PoolSize {
+		return fmt.Errorf("redis: MinIdleConns %d above PoolSize %d", opts.MinIdleConns, opts.PoolSize)
+	}
+	if opts.MinRetryBackoff > 0 && opts.MaxRetryBackoff > 0 && opts.MinRetryBackoff > opts.MaxRetryBackoff {
+		return fmt.Errorf("redis: MinRetryBackoff %s above MaxRetryBackoff %s", opts.MinRetryBackoff, opts.MaxRetryBackoff)
+	}
+	if (opts.TLSCertFile == "") != (opts.TLSKeyFile == "") {
+		return errors.New("redis: TLSCertFile and TLSKeyFile must be set together")
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS config of opts, nil without TLS.
+func (opts Options) tlsConfig() (*tls.Config, error) {
+	if !opts.TLS && opts.TLSCAFile == "" && opts.TLSCertFile == "" &&
+		opts.TLSServerName == "" && !opts.TLSInsecureSkipVerify {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.TLSServerName,
+		InsecureSkipVerify: opts.TLSInsecureSkipVerify,
+	}
+	if opts.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis: read TLS CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis: no certificates in TLS CA file %s", opts.TLSCAFile)
+		}
+	}
+	if opts.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis: load TLS client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// newClient creates the go-redis client of validated opts.
+func (opts Options) newClient() (redis.UniversalClient, error) {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch opts.ClusterMode {
+	case ClusterModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:              opts.Endpoints,
+			ReadOnly:           opts.ReadOnly,
+			RouteByLatency:     opts.RouteByLatency,
+			Username:           opts.Username,
+			Password:           opts.Password,
+			MaxRetries:         opts.MaxRetries,
+			MinRetryBackoff:    opts.MinRetryBackoff,
+			MaxRetryBackoff:    opts.MaxRetryBackoff,
+			DialTimeout:        opts.DialTimeout,
+			ReadTimeout:        opts.ReadTimeout,
+			WriteTimeout:       opts.WriteTimeout,
+			PoolSize:           opts.PoolSize,
+			MinIdleConns:       opts.MinIdleConns,
+			MaxConnAge:         opts.MaxConnAge,
+			PoolTimeout:        opts.PoolTimeout,
+			IdleTimeout:        opts.IdleTimeout,
+			IdleCheckFrequency: opts.IdleCheckFrequency,
+			TLSConfig:          tlsConfig,
+		}), nil
+	case ClusterModeSentinel:
+		failover := &redis.FailoverOptions{
+			MasterName:         opts.SentinelMasterName,
+			SentinelAddrs:      opts.Endpoints,
+			RouteByLatency:     opts.RouteByLatency,
+			SlaveOnly:          opts.ReadOnly,
+			Username:           opts.Username,
+			Password:           opts.Password,
+			DB:                 opts.DB,
+			MaxRetries:         opts.MaxRetries,
+			MinRetryBackoff:    opts.MinRetryBackoff,
+			MaxRetryBackoff:    opts.MaxRetryBackoff,
+			DialTimeout:        opts.DialTimeout,
+			ReadTimeout:        opts.ReadTimeout,
+			WriteTimeout:       opts.WriteTimeout,
+			PoolSize:           opts.PoolSize,
+			MinIdleConns:       opts.MinIdleConns,
+			MaxConnAge:         opts.MaxConnAge,
+			PoolTimeout:        opts.PoolTimeout,
+			IdleTimeout:        opts.IdleTimeout,
+			IdleCheckFrequency: opts.IdleCheckFrequency,
+			TLSConfig:          tlsConfig,
+		}
+		if opts.RouteByLatency {
+			// only the cluster client routes between master and replicas
+			return redis.NewFailoverClusterClient(failover), nil
+		}
+		return redis.NewFailoverClient(failover), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:               opts.Endpoints[0],
+			Username:           opts.Username,
+			Password:           opts.Password,
+			DB:                 opts.DB,
+			MaxRetries:         opts.MaxRetries,
+			MinRetryBackoff:    opts.MinRetryBackoff,
+			MaxRetryBackoff:    opts.MaxRetryBackoff,
+			DialTimeout:        opts.DialTimeout,
+			ReadTimeout:        opts.ReadTimeout,
+			WriteTimeout:       opts.WriteTimeout,
+			PoolSize:           opts.PoolSize,
+			MinIdleConns:       opts.MinIdleConns,
+			MaxConnAge:         opts.MaxConnAge,
+			PoolTimeout:        opts.PoolTimeout,
+			IdleTimeout:        opts.IdleTimeout,
+			IdleCheckFrequency: opts.IdleCheckFrequency,
+			TLSConfig:          tlsConfig,
+		}), nil
+	}
+}
@@ -2,20 +2,26 @@ package redis
 
 import (
 	"context"
-	"errors"
-	"strings"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redis_rate/v9"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 
 	"github.com/zhlls/go-common/log"
 )
 
+// Client is the client of the default Store.
+//
+// Deprecated: use Default().Client().
+var Client redis.UniversalClient
+
 var (
-	Client         redis.UniversalClient
-	redisOPLatency prometheus.ObserverVec
+	defaultStore *Store
+	setLogger    sync.Once
 )
 
 const (
@@ -23,140 +29,261 @@ const (
 	ClusterModeCluster  = "Cluster"
 	ClusterModeSentinel = "Sentinel"
 	MetricNamespace     = "notifier"
+
+	// DefaultName is the name of a Store created without Options.Name.
+	DefaultName = "default"
 )
 
-type Options struct {
-	Registerer prometheus.Registerer
+var scope = log.RegisterScope("redis", "Redis client messages.", 0)
 
-	Endpoints          []string
-	Password           string
-	ClusterMode        string
-	SentinelMasterName string
-	DB                 int
-	PoolSize           int
-	MinIdleConns       int
+// Store is a connection to one Redis deployment with its own metrics and
+// logger. Services talking to several deployments create one per
+// deployment with NewStore.
+type Store struct {
+	name    string
+	client  redis.UniversalClient
+	logger  *log.Scope
+	metrics *storeMetrics
 }
 
-func NewRedisClient(opts Options) (redis.UniversalClient, error) {
-	if len(opts.Endpoints) == 0 {
-		return nil, errors.New("invalid redis endpoint")
+// NewRedisClient creates a Store with NewStore and makes it the default
+// Store used by the package level functions.
+func NewRedisClient(opts Options) (*Store, error) {
+	s, err := NewStore(opts)
+	if err != nil {
+		return nil, err
+	}
+	SetDefault(s)
+	return s, nil
+}
+
+// NewStore connects to the deployment configured by opts and checks it
+// with a PING.
+func NewStore(opts Options) (*Store, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+	if opts.Logger == nil {
+		opts.Logger = scope
 	}
-	redis.SetLogger(logger{})
-	switch opts.ClusterMode {
-	case ClusterModeSingle:
-		Client = redis.NewClient(&redis.Options{
-			Addr:         opts.Endpoints[0],
-			Password:     opts.Password,
-			DB:           opts.DB,
-			PoolSize:     opts.PoolSize,
-			MinIdleConns: opts.MinIdleConns,
-		})
-	case ClusterModeCluster:
-		Client = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:        opts.Endpoints,
-			Password:     opts.Password,
-			PoolSize:     opts.PoolSize,
-			MinIdleConns: opts.MinIdleConns,
-		})
-	case ClusterModeSentinel:
-		if opts.SentinelMasterName == "" {
-			return nil, errors.New("redis sentinel no master")
-		}
-		Client = redis.NewFailoverClient(&redis.FailoverOptions{
-			MasterName:    opts.SentinelMasterName,
-			SentinelAddrs: opts.Endpoints,
-			Password:      opts.Password,
-			DB:            opts.DB,
-			PoolSize:      opts.PoolSize,
-			MinIdleConns:  opts.MinIdleConns,
-		})
-	default:
-		return nil, errors.New("invalid redis mode")
+	if opts.Namespace == "" {
+		opts.Namespace = MetricNamespace
 	}
+	if opts.KeyPattern == nil {
+		opts.KeyPattern = KeyPrefix
+	}
+	setLogger.Do(func() {
+		redis.SetLogger(logger{})
+	})
+
+	client, err := opts.newClient()
+	if err != nil {
+		return nil, err
+	}
+	client.AddHook(tracingHook{db: strconv.Itoa(opts.DB), keyPattern: opts.KeyPattern})
 
-	_, err := Client.Ping(Client.Context()).Result()
+	_, err = client.Ping(client.Context()).Result()
 	if err != nil {
+		_ = client.Close()
 		return nil, err
 	}
 
-	//addQueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-	//	Namespace: utils.MetricNamespace,
-	//	Name:      "add_queue_latency_seconds",
-	//	Help:      "The latency of alerts add to queue.",
-	//	Buckets:   []float64{0.001, 0.002, 0.005, 0.01, 0.05, 0.1, 1},
-	//}, []string{"op", "key"})
-	redisOPLatency = prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace:  MetricNamespace,
-		Name:       "redis_op_latency_seconds",
-		Help:       "The latency of add something to redis.",
-		Objectives: map[float64]float64{0.5: 0.05, 0.95: 0.005, 0.99: 0.001},
-	}, []string{"op", "key"})
-	opts.Registerer.MustRegister(redisOPLatency)
+	metrics, err := newStoreMetrics(opts, client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
 
-	return Client, nil
+	opts.Logger.Info("redis connected", zap.String("name", opts.Name))
+	return &Store{
+		name:    opts.Name,
+		client:  client,
+		logger:  opts.Logger,
+		metrics: metrics,
+	}, nil
 }
 
-func Close() error {
-	return Client.Close()
+// Default returns the default Store, nil before NewRedisClient or
+// SetDefault.
+func Default() *Store {
+	return defaultStore
 }
 
-func Set(key string, value interface{}, max_life int64) error {
+// SetDefault makes s the default Store used by the package level
+// functions.
+func SetDefault(s *Store) {
+	defaultStore = s
+	Client = s.client
+}
+
+// Name returns the name of the store.
+func (s *Store) Name() string {
+	return s.name
+}
+
+// Client returns the underlying go-redis client.
+func (s *Store) Client() redis.UniversalClient {
+	return s.client
+}
+
+func (s *Store) Close() error {
+	s.metrics.unregister()
+	return s.client.Close()
+}
+
+// Set stores value under key for max_life seconds.
+func (s *Store) Set(key string, value interface{}, max_life int64) error {
+	return s.SetContext(s.client.Context(), key, value, max_life)
+}
+
+// SetContext is Set bound to ctx.
+func (s *Store) SetContext(ctx context.Context, key string, value interface{}, max_life int64) error {
 	now := time.Now()
-	cmd := Client.SetEX(Client.Context(), key, value, time.Duration(max_life)*time.Second)
-	redisOPLatency.WithLabelValues("set", key).Observe(time.Since(now).Seconds())
+	cmd := s.client.SetEX(ctx, key, value, time.Duration(max_life)*time.Second)
+	s.metrics.observe("set", key, now, cmd.Err())
 	return cmd.Err()
 }
 
-func Get(key string) (string, error) {
+// Get returns the value of key, redis.Nil when it does not exist.
+func (s *Store) Get(key string) (string, error) {
+	return s.GetContext(s.client.Context(), key)
+}
+
+// GetContext is Get bound to ctx.
+func (s *Store) GetContext(ctx context.Context, key string) (string, error) {
 	now := time.Now()
-	cmd := Client.Get(Client.Context(), key)
-	redisOPLatency.WithLabelValues("get", key).Observe(time.Since(now).Seconds())
+	cmd := s.client.Get(ctx, key)
+	s.metrics.observe("get", key, now, cmd.Err())
 	return cmd.Val(), cmd.Err()
 }
 
-func AddQueue(key string, values ...interface{}) error {
+// AddQueue pushes values to the queue key.
+func (s *Store) AddQueue(key string, values ...interface{}) error {
+	return s.AddQueueContext(s.client.Context(), key, values...)
+}
+
+// AddQueueContext is AddQueue bound to ctx.
+func (s *Store) AddQueueContext(ctx context.Context, key string, values ...interface{}) error {
 	now := time.Now()
-	cmd := Client.LPush(Client.Context(), key, values...)
-	redisOPLatency.WithLabelValues("lpush", key).Observe(time.Since(now).Seconds())
+	cmd := s.client.LPush(ctx, key, values...)
+	s.metrics.observe("lpush", key, now, cmd.Err())
 	return cmd.Err()
 }
 
-func FetchQueue(ctx context.Context, timeout time.Duration, keys ...string) (string, string, error) {
-	cmd := Client.BRPop(ctx, timeout, keys...)
+func (s *Store) FetchQueue(ctx context.Context, timeout time.Duration, keys ...string) (string, string, error) {
+	cmd := s.client.BRPop(ctx, timeout, keys...)
 	if cmd.Err() != nil {
 		return "", "", cmd.Err()
 	}
 	return cmd.Val()[0], cmd.Val()[1], nil
 }
 
-func QueueLen(ctx context.Context, key string) (int64, error) {
-	cmd := Client.LLen(ctx, key)
+func (s *Store) QueueLen(ctx context.Context, key string) (int64, error) {
+	cmd := s.client.LLen(ctx, key)
 	if cmd.Err() != nil {
 		return 0, cmd.Err()
 	}
 	return cmd.Val(), nil
 }
 
-func NewRateLimiter() *redis_rate.Limiter {
-	return redis_rate.NewLimiter(Client)
+func (s *Store) NewRateLimiter() *redis_rate.Limiter {
+	return redis_rate.NewLimiter(s.client)
 }
 
-func Expire(key string, max_life int64) error {
+// Expire sets the time to live of key to max_life seconds.
+func (s *Store) Expire(key string, max_life int64) error {
+	return s.ExpireContext(s.client.Context(), key, max_life)
+}
+
+// ExpireContext is Expire bound to ctx.
+func (s *Store) ExpireContext(ctx context.Context, key string, max_life int64) error {
 	now := time.Now()
-	cmd := Client.Expire(Client.Context(), key, time.Duration(max_life)*time.Second)
-	redisOPLatency.WithLabelValues("expire", key).Observe(time.Since(now).Seconds())
+	cmd := s.client.Expire(ctx, key, time.Duration(max_life)*time.Second)
+	s.metrics.observe("expire", key, now, cmd.Err())
 	return cmd.Err()
 }
 
-func Delete(keys ...string) error {
+// Delete removes keys.
+func (s *Store) Delete(keys ...string) error {
+	return s.DeleteContext(s.client.Context(), keys...)
+}
+
+// DeleteContext is Delete bound to ctx.
+func (s *Store) DeleteContext(ctx context.Context, keys ...string) error {
 	now := time.Now()
-	cmd := Client.Del(Client.Context(), keys...)
-	redisOPLatency.WithLabelValues("delete", strings.Join(keys, "/")).Observe(time.Since(now).Seconds())
+	cmd := s.client.Del(ctx, keys...)
+	var key string
+	if len(keys) > 0 {
+		key = keys[0]
+	}
+	s.metrics.observe("delete", key, now, cmd.Err())
 	return cmd.Err()
 }
 
+func Close() error {
+	return defaultStore.Close()
+}
+
+func Set(key string, value interface{}, max_life int64) error {
+	return defaultStore.Set(key, value, max_life)
+}
+
+func SetContext(ctx context.Context, key string, value interface{}, max_life int64) error {
+	return defaultStore.SetContext(ctx, key, value, max_life)
+}
+
+func Get(key string) (string, error) {
+	return defaultStore.Get(key)
+}
+
+func GetContext(ctx context.Context, key string) (string, error) {
+	return defaultStore.GetContext(ctx, key)
+}
+
+func AddQueue(key string, values ...interface{}) error {
+	return defaultStore.AddQueue(key, values...)
+}
+
+func AddQueueContext(ctx context.Context, key string, values ...interface{}) error {
+	return defaultStore.AddQueueContext(ctx, key, values...)
+}
+
+func FetchQueue(ctx context.Context, timeout time.Duration, keys ...string) (string, string, error) {
+	return defaultStore.FetchQueue(ctx, timeout, keys...)
+}
+
+func QueueLen(ctx context.Context, key string) (int64, error) {
+	return defaultStore.QueueLen(ctx, key)
+}
+
+func NewRateLimiter() *redis_rate.Limiter {
+	return defaultStore.NewRateLimiter()
+}
+
+func Expire(key string, max_life int64) error {
+	return defaultStore.Expire(key, max_life)
+}
+
+func ExpireContext(ctx context.Context, key string, max_life int64) error {
+	return defaultStore.ExpireContext(ctx, key, max_life)
+}
+
+func Delete(keys ...string) error {
+	return defaultStore.Delete(keys...)
+}
+
+func DeleteContext(ctx context.Context, keys ...string) error {
+	return defaultStore.DeleteContext(ctx, keys...)
+}
+
 type logger struct{}
 
 func (l logger) Printf(_ context.Context, format string, v ...interface{}) {
-	log.Infof(format, v...)
+	scope.Infof(format, v...)
 }
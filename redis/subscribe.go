@@ -15,6 +15,7 @@ type Subscriber interface {
 }
 
 type notifier struct {
+	store  *Store
 	logger log.Logger
 
 	key       string
@@ -37,7 +38,7 @@ func (n *notifier) Run() {
 		case <-n.ctx.Done():
 			return
 		default:
-			_, v, err := FetchQueue(n.ctx, timeout, n.key)
+			_, v, err := n.store.FetchQueue(n.ctx, timeout, n.key)
 			if err != nil {
 				if err == redisV8.Nil || err == context.Canceled {
 					continue
@@ -59,10 +60,18 @@ func (n *notifier) Stop() {
 	<-n.done
 }
 
+// Subscribe processes the values pushed to the queue key of the default
+// Store.
 func Subscribe(logger log.Logger, key string, processor func(string)) Subscriber {
+	return defaultStore.Subscribe(logger, key, processor)
+}
+
+// Subscribe processes the values pushed to the queue key.
+func (s *Store) Subscribe(logger log.Logger, key string, processor func(string)) Subscriber {
 	n := notifier{
-		logger: logger,
-		key: key,
+		store:     s,
+		logger:    logger,
+		key:       key,
 		processor: processor,
 	}
 	return &n
@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+type spanKey struct{}
+
+// tracingHook creates a child span for every command and pipeline sent with
+// a context carrying a span, e.g. the one returned by GetTraceContext of
+// server/http:
+//
+//	v, err := redis.GetContext(http.GetTraceContext(ctx), "user:42")
+//
+// Commands without a parent span, like the polling of Subscribe, are not
+// traced.
+type tracingHook struct {
+	db         string
+	keyPattern func(string) string
+}
+
+var _ redis.Hook = tracingHook{}
+
+func (h tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if opentracing.SpanFromContext(ctx) == nil {
+		return ctx, nil
+	}
+	span, ctx := opentracing.StartSpanFromContext(ctx, "redis."+cmd.FullName(), ext.SpanKindRPCClient)
+	h.setTags(span)
+	span.SetTag("redis.command", cmd.FullName())
+	if prefix, ok := h.firstKey(cmd); ok {
+		span.SetTag("redis.key_prefix", prefix)
+	}
+	return context.WithValue(ctx, spanKey{}, span), nil
+}
+
+func (h tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if span, ok := ctx.Value(spanKey{}).(opentracing.Span); ok {
+		finishSpan(span, cmd.Err())
+	}
+	return nil
+}
+
+func (h tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if opentracing.SpanFromContext(ctx) == nil {
+		return ctx, nil
+	}
+	span, ctx := opentracing.StartSpanFromContext(ctx, "redis.pipeline", ext.SpanKindRPCClient)
+	h.setTags(span)
+	names := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		names = append(names, cmd.FullName())
+	}
+	span.SetTag("redis.command", strings.Join(names, " "))
+	span.SetTag("redis.num_cmd", len(cmds))
+	if len(cmds) > 0 {
+		if prefix, ok := h.firstKey(cmds[0]); ok {
+			span.SetTag("redis.key_prefix", prefix)
+		}
+	}
+	return context.WithValue(ctx, spanKey{}, span), nil
+}
+
+func (h tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	span, ok := ctx.Value(spanKey{}).(opentracing.Span)
+	if !ok {
+		return nil
+	}
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && cmd.Err() != redis.Nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	finishSpan(span, err)
+	return nil
+}
+
+func (h tracingHook) setTags(span opentracing.Span) {
+	ext.DBType.Set(span, "redis")
+	ext.DBInstance.Set(span, h.db)
+}
+
+func finishSpan(span opentracing.Span, err error) {
+	// a missing key is a result, not a failure
+	if err != nil && err != redis.Nil {
+		ext.LogError(span, err)
+	}
+	span.Finish()
+}
+
+// firstKey returns the pattern of the first key of cmd, so ids in keys do
+// not end up in traces.
+func (h tracingHook) firstKey(cmd redis.Cmder) (string, bool) {
+	args := cmd.Args()
+	pos := 1
+	switch cmd.Name() {
+	case "eval", "evalsha":
+		// EVAL script numkeys key...
+		if len(args) < 4 {
+			return "", false
+		}
+		if numKeys(args[2]) == 0 {
+			return "", false
+		}
+		pos = 3
+	case "ping", "info", "select", "auth", "hello", "script", "cluster", "client", "command":
+		return "", false
+	}
+	if len(args) <= pos {
+		return "", false
+	}
+	key, ok := args[pos].(string)
+	if !ok {
+		return "", false
+	}
+	return h.keyPattern(key), true
+}
+
+func numKeys(arg interface{}) int {
+	switch v := arg.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func useMockTracer(t *testing.T) *mocktracer.MockTracer {
+	tracer := mocktracer.New()
+	prev := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() { opentracing.SetGlobalTracer(prev) })
+	return tracer
+}
+
+// tracedContext returns a context carrying a parent span.
+func tracedContext(tracer *mocktracer.MockTracer) (context.Context, *mocktracer.MockSpan) {
+	parent := tracer.StartSpan("parent").(*mocktracer.MockSpan)
+	return opentracing.ContextWithSpan(context.Background(), parent), parent
+}
+
+func TestTracingSpanPerCommand(t *testing.T) {
+	tracer := useMockTracer(t)
+	s, _ := newTestStore(t)
+	ctx, parent := tracedContext(tracer)
+
+	if err := s.SetContext(ctx, "user:42", "alice", 60); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetContext(ctx, "user:42"); err != nil {
+		t.Fatal(err)
+	}
+	// commands without a parent span are not traced
+	if _, err := s.Get("user:42"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	for i, name := range []string{"redis.setex", "redis.get"} {
+		sp := spans[i]
+		if sp.OperationName != name || sp.ParentID != parent.SpanContext.SpanID {
+			t.Errorf("span %q with parent %d, want %q of the parent span", sp.OperationName, sp.ParentID, name)
+		}
+		if sp.Tag("db.type") != "redis" || sp.Tag("redis.key_prefix") != "user" || sp.Tag("error") != nil {
+			t.Errorf("%s: tags %v", name, sp.Tags())
+		}
+	}
+}
+
+func TestTracingPipeline(t *testing.T) {
+	tracer := useMockTracer(t)
+	s, _ := newTestStore(t)
+	ctx, _ := tracedContext(tracer)
+
+	_, err := s.Client().Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, "user:1", "a", 0)
+		p.Get(ctx, "user:missing")
+		return nil
+	})
+	if err != redis.Nil {
+		t.Fatalf("pipeline: %v, want redis.Nil of the missing key", err)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want one for the pipeline", len(spans))
+	}
+	sp := spans[0]
+	if sp.OperationName != "redis.pipeline" || sp.Tag("redis.num_cmd") != 2 ||
+		sp.Tag("redis.command") != "set get" || sp.Tag("redis.key_prefix") != "user" {
+		t.Errorf("span %q tags %v", sp.OperationName, sp.Tags())
+	}
+	if sp.Tag("error") != nil {
+		t.Error("missing key tagged as error")
+	}
+}
+
+func TestTracingEvalKey(t *testing.T) {
+	tracer := useMockTracer(t)
+	s, _ := newTestStore(t)
+	ctx, _ := tracedContext(tracer)
+
+	if err := s.Client().Eval(ctx, "return 1", []string{"lock:order:7"}, "token").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Client().Eval(ctx, "return 1", nil, "user:1").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if got := spans[0].Tag("redis.key_prefix"); got != "lock" {
+		t.Errorf("eval key prefix %v, want the first key, not the script", got)
+	}
+	if got := spans[1].Tag("redis.key_prefix"); got != nil {
+		t.Errorf("eval without keys tagged key prefix %v of an argument", got)
+	}
+}
+
+func TestTracingErrors(t *testing.T) {
+	tracer := useMockTracer(t)
+	s, _ := newTestStore(t)
+	ctx, _ := tracedContext(tracer)
+
+	if _, err := s.GetContext(ctx, "user:missing"); err != redis.Nil {
+		t.Fatalf("get missing key: %v, want redis.Nil", err)
+	}
+	if err := s.SetContext(ctx, "user:1", "a", 60); err != nil {
+		t.Fatal(err)
+	}
+	// INCR of a non-integer value fails
+	if err := s.Client().Incr(ctx, "user:1").Err(); err == nil {
+		t.Fatal("incr of a string succeeded")
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	if spans[0].Tag("error") != nil {
+		t.Error("redis.Nil tagged as error")
+	}
+	if spans[2].Tag("error") != true || len(spans[2].Logs()) == 0 {
+		t.Errorf("failed command: tags %v, want the error", spans[2].Tags())
+	}
+}
+
+func TestContextDeadlineReachesRedis(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// BRPOP would block for a minute without the deadline of ctx
+	_, _, err := s.FetchQueue(ctx, time.Minute, "queue:empty")
+	if err == nil {
+		t.Fatal("fetch from an empty queue succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch returned after %v, want the deadline of the context", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.GetContext(ctx, "user:1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("get with cancelled context: %v, want context.Canceled", err)
+	}
+	if err := s.SetContext(ctx, "user:1", "a", 60); !errors.Is(err, context.Canceled) {
+		t.Errorf("set with cancelled context: %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,373 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+
+	skipCompressionKey = "__skip_compression__"
+)
+
+var (
+	errBodyTooLarge        = errors.New("decompressed body too large")
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+	// defaultCompressibleTypes leaves out types which are compressed
+	// already, like application/zip, application/pdf or font/woff2
+	defaultCompressibleTypes = []string{
+		"text/",
+		"application/json",
+		"application/javascript",
+		"application/x-javascript",
+		"application/xml",
+		"application/xhtml+xml",
+		"application/rss+xml",
+		"application/atom+xml",
+		"application/ld+json",
+		"application/manifest+json",
+		"application/problem+json",
+		"application/graphql",
+		"application/wasm",
+		"application/x-www-form-urlencoded",
+		"image/svg",
+		"image/x-icon",
+		"image/bmp",
+		"font/ttf",
+		"font/otf",
+		"multipart/",
+	}
+)
+
+// CompressionOptions configures compression of response bodies.
+type CompressionOptions struct {
+	// Disable turns response compression off.
+	Disable bool
+
+	// Algorithms are the encodings offered, in order of preference.
+	// Supported are EncodingBrotli, EncodingGzip and EncodingDeflate.
+	Algorithms []string
+
+	// Level is the gzip and deflate compression level.
+	Level int
+
+	// BrotliLevel is the brotli compression level.
+	BrotliLevel int
+
+	// MinSize is the body size in bytes below which responses are sent
+	// uncompressed.
+	MinSize int
+
+	// ContentTypes are the Content-Type prefixes which are compressed.
+	ContentTypes []string
+}
+
+// DefaultCompressionOptions matches the former fasthttp.CompressHandler
+// behaviour: gzip or deflate at the default level for textual content.
+func DefaultCompressionOptions() CompressionOptions {
+	return CompressionOptions{
+		Algorithms:   []string{EncodingGzip, EncodingDeflate},
+		Level:        fasthttp.CompressDefaultCompression,
+		BrotliLevel:  fasthttp.CompressBrotliDefaultCompression,
+		ContentTypes: defaultCompressibleTypes,
+	}
+}
+
+// DecompressionOptions configures decoding of compressed request bodies.
+type DecompressionOptions struct {
+	// Disable leaves request bodies untouched.
+	Disable bool
+
+	// MaxSize is the limit of a decompressed body in bytes, larger bodies
+	// are rejected with 413 to guard against zip bombs.
+	MaxSize int
+}
+
+// SetCompression replaces the response compression options.
+func (s *Server) SetCompression(opts CompressionOptions) {
+	s.compression = opts
+}
+
+// SetDecompression replaces the request decompression options.
+func (s *Server) SetDecompression(opts DecompressionOptions) {
+	s.decompression = opts
+}
+
+// SkipCompression makes the current response go out uncompressed.
+func SkipCompression(ctx *fasthttp.RequestCtx) {
+	ctx.SetUserValue(skipCompressionKey, true)
+}
+
+// NoCompression wraps a route handler whose responses are never compressed.
+func NoCompression(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		SkipCompression(ctx)
+		h(ctx)
+	}
+}
+
+func (s *Server) compressMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	opts := s.compression
+	if opts.Disable || len(opts.Algorithms) == 0 {
+		return h
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+
+		if skip, _ := ctx.UserValue(skipCompressionKey).(bool); skip {
+			return
+		}
+		resp := &ctx.Response
+		// streamed bodies, like static files, would have to be buffered,
+		// serve them with StaticOptions.Precompressed instead; partial
+		// content must keep the byte offsets of the identity encoding
+		if resp.IsBodyStream() || resp.StatusCode() == fasthttp.StatusPartialContent ||
+			len(resp.Header.PeekBytes(StrContentEncoding)) > 0 ||
+			!compressibleType(resp.Header.ContentType(), opts.ContentTypes) {
+			return
+		}
+
+		encoding := negotiateEncoding(ctx, opts.Algorithms)
+		if encoding == "" {
+			return
+		}
+		// the bytes differ by encoding while the content does not, so the
+		// same strong ETag must not be sent for both; HEAD and 304 Not
+		// Modified responses carry the ETag of the GET response
+		weakenETag(resp)
+		body := resp.Body()
+		if ctx.IsHead() || len(body) < opts.MinSize || len(body) == 0 {
+			return
+		}
+
+		var compressed []byte
+		switch encoding {
+		case EncodingBrotli:
+			compressed = fasthttp.AppendBrotliBytesLevel(nil, body, opts.BrotliLevel)
+		case EncodingGzip:
+			compressed = fasthttp.AppendGzipBytesLevel(nil, body, opts.Level)
+		case EncodingDeflate:
+			compressed = fasthttp.AppendDeflateBytesLevel(nil, body, opts.Level)
+		}
+		addVary(&resp.Header, strAcceptEncoding)
+		if len(compressed) >= len(body) {
+			return
+		}
+		resp.SetBodyRaw(compressed)
+		resp.Header.SetBytesK(StrContentEncoding, encoding)
+	}
+}
+
+// weakenETag turns a strong ETag of resp into a weak one.
+func weakenETag(resp *fasthttp.Response) {
+	etag := resp.Header.PeekBytes(strETag)
+	if len(etag) > 0 && !bytes.HasPrefix(etag, strWeakPrefix) {
+		resp.Header.SetBytesKV(strETag, append(append([]byte(nil), strWeakPrefix...), etag...))
+	}
+}
+
+// addVary adds name to the Vary header of h unless it is listed already.
+func addVary(h *fasthttp.ResponseHeader, name []byte) {
+	found := false
+	h.VisitAll(func(key, value []byte) {
+		if !bytes.EqualFold(key, strVary) {
+			return
+		}
+		for _, v := range bytes.Split(value, []byte(",")) {
+			v = bytes.TrimSpace(v)
+			if bytes.EqualFold(v, name) || bytes.Equal(v, []byte("*")) {
+				found = true
+			}
+		}
+	})
+	if !found {
+		h.AddBytesKV(strVary, name)
+	}
+}
+
+// negotiateEncoding returns the one of algorithms the Accept-Encoding header
+// of ctx gives the highest q-value, the first of them on a tie, "" when
+// none is acceptable.
+func negotiateEncoding(ctx *fasthttp.RequestCtx, algorithms []string) string {
+	accepted := acceptedEncodings(ctx)
+	best, bestQ := "", 0.0
+	for _, a := range algorithms {
+		if q := accepted.q(a); q > bestQ {
+			best, bestQ = a, q
+		}
+	}
+	return best
+}
+
+// acceptEncoding maps the lower case codings of an Accept-Encoding header to
+// their q-values.
+type acceptEncoding map[string]float64
+
+// acceptedEncodings parses the Accept-Encoding headers of ctx, elements with
+// an invalid q-value are skipped.
+func acceptedEncodings(ctx *fasthttp.RequestCtx) acceptEncoding {
+	accepted := acceptEncoding{}
+	for _, element := range strings.Split(string(headerValues(&ctx.Request.Header, strAcceptEncoding)), ",") {
+		params := strings.Split(element, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || (param[0] != 'q' && param[0] != 'Q') || param[1] != '=' {
+				continue
+			}
+			v, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || v < 0 || v > 1 {
+				q = -1
+			} else {
+				q = v
+			}
+		}
+		if q >= 0 {
+			accepted[coding] = q
+		}
+	}
+	return accepted
+}
+
+// q returns the q-value of encoding, which "*" gives when it is not listed,
+// zero when it is not acceptable.
+func (a acceptEncoding) q(encoding string) float64 {
+	if q, ok := a[encoding]; ok {
+		return q
+	}
+	if encoding == EncodingGzip {
+		if q, ok := a["x-gzip"]; ok {
+			return q
+		}
+	}
+	return a["*"]
+}
+
+func compressibleType(contentType []byte, allow []string) bool {
+	for _, prefix := range allow {
+		if bytes.HasPrefix(contentType, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) decompressMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	opts := s.decompression
+	if opts.Disable {
+		return h
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxRequestBodySize
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		encoding := ctx.Request.Header.PeekBytes(StrContentEncoding)
+		if len(encoding) == 0 || bytes.EqualFold(encoding, []byte("identity")) {
+			h(ctx)
+			return
+		}
+
+		body, err := decompressBody(ctx.Request.Body(), string(encoding), opts.MaxSize)
+		if err != nil {
+			log.Debug("request body decompress failed",
+				zap.ByteString("method", ctx.Method()),
+				zap.ByteString("path", ctx.Request.RequestURI()),
+				zap.ByteString("encoding", encoding),
+				zap.Error(err),
+			)
+			switch err {
+			case errBodyTooLarge:
+				Failed(ctx, fasthttp.StatusRequestEntityTooLarge, err.Error())
+			case errUnsupportedEncoding:
+				Failed(ctx, fasthttp.StatusUnsupportedMediaType, err.Error())
+			default:
+				Failed(ctx, fasthttp.StatusBadRequest, "invalid compressed body: "+err.Error())
+			}
+			return
+		}
+
+		ctx.Request.Header.DelBytes(StrContentEncoding)
+		ctx.Request.SetBodyRaw(body)
+		h(ctx)
+	}
+}
+
+// decompressBody decodes body encoded with the comma separated encodings,
+// which are applied in the listed order and therefore undone in reverse.
+func decompressBody(body []byte, encodings string, maxSize int) ([]byte, error) {
+	list := strings.Split(encodings, ",")
+	for i := len(list) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(list[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+
+		var r io.Reader
+		src := bytes.NewReader(body)
+		switch encoding {
+		case EncodingGzip, "x-gzip":
+			zr, err := gzip.NewReader(src)
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			r = zr
+		case EncodingDeflate:
+			// deflate is zlib wrapped per RFC 9110, but raw deflate is common
+			if zr, err := zlib.NewReader(src); err == nil {
+				defer zr.Close()
+				r = zr
+			} else {
+				fr := flate.NewReader(bytes.NewReader(body))
+				defer fr.Close()
+				r = fr
+			}
+		case EncodingBrotli:
+			r = brotli.NewReader(src)
+		case EncodingZstd:
+			zr, err := zstd.NewReader(src)
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			r = zr
+		default:
+			return nil, errUnsupportedEncoding
+		}
+
+		decoded, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > maxSize {
+			return nil, errBodyTooLarge
+		}
+		body = decoded
+	}
+	return body, nil
+}
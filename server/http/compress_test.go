@@ -0,0 +1,145 @@
+package http_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	nethttp "net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/zhlls/go-common/server/http"
+	"github.com/zhlls/go-common/server/http/httptest"
+)
+
+var largeText = bytes.Repeat([]byte("compressible text "), 512)
+
+func init() {
+	http.AddRouter(fasthttp.MethodGet, "/compress/{type}", func(ctx *fasthttp.RequestCtx) {
+		switch ctx.UserValue("type") {
+		case "text":
+			ctx.SetContentType("text/plain; charset=utf-8")
+		case "zip":
+			ctx.SetContentType("application/zip")
+		case "partial":
+			ctx.SetContentType("text/plain")
+			ctx.SetStatusCode(fasthttp.StatusPartialContent)
+		case "vary":
+			ctx.SetContentType("text/plain")
+			ctx.Response.Header.Add("Vary", "accept-encoding")
+		case "vary-origin":
+			ctx.SetContentType("text/plain")
+			ctx.Response.Header.Add("Vary", "Origin")
+		}
+		ctx.SetBody(largeText)
+	})
+}
+
+func TestCompression(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "large.txt"), largeText, 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := http.NewServer("")
+	srv.Static("/static", http.StaticOptions{Root: nethttp.Dir(dir)})
+	ts := httptest.NewServer(t, srv)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		path     string
+		encoding string
+	}{
+		{"/rest/compress/text", "gzip"},
+		{"/rest/compress/zip", ""},
+		{"/rest/compress/partial", ""},
+		// streamed file bodies are not buffered for compression
+		{"/static/large.txt", ""},
+	} {
+		resp := ts.GET(tc.path).WithHeader("Accept-Encoding", "gzip").Expect().Raw()
+		if got := string(resp.Header.Peek("Content-Encoding")); got != tc.encoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tc.path, got, tc.encoding)
+		}
+	}
+
+	resp := ts.GET("/static/large.txt").
+		WithHeader("Accept-Encoding", "gzip").
+		WithHeader("Range", "bytes=0-9").
+		Expect().
+		Status(fasthttp.StatusPartialContent).
+		Raw()
+	if got := string(resp.Body()); got != string(largeText[:10]) {
+		t.Errorf("range body: got %q", got)
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	srv := http.NewServer("")
+	opts := http.DefaultCompressionOptions()
+	opts.Algorithms = []string{http.EncodingBrotli, http.EncodingGzip, http.EncodingDeflate}
+	srv.SetCompression(opts)
+	ts := httptest.NewServer(t, srv)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		accept   string
+		encoding string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"x-gzip", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip; q=0, deflate", "deflate"},
+		{"gzip;q=0.5, br;q=0.8", "br"},
+		// ties go to the order of the algorithms
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, br;q=0.5", "br"},
+		{"*", "br"},
+		{"*;q=0", ""},
+		{"br;q=0, *;q=0.1", "gzip"},
+		{"deflate, *;q=0", "deflate"},
+		{"gzip;q=invalid", ""},
+		{"gzip;q=2", ""},
+	} {
+		resp := ts.GET("/rest/compress/text").WithHeader("Accept-Encoding", tc.accept).Expect().Raw()
+		if got := string(resp.Header.Peek("Content-Encoding")); got != tc.encoding {
+			t.Errorf("%q: Content-Encoding %q, want %q", tc.accept, got, tc.encoding)
+		}
+	}
+
+	// repeated headers are one list
+	req := ts.GET("/rest/compress/text")
+	req.Raw().Header.Add("Accept-Encoding", "gzip;q=0.1")
+	req.Raw().Header.Add("Accept-Encoding", "deflate")
+	if got := string(req.Expect().Raw().Header.Peek("Content-Encoding")); got != "deflate" {
+		t.Errorf("repeated headers: Content-Encoding %q, want deflate", got)
+	}
+}
+
+func TestCompressionVary(t *testing.T) {
+	ts := httptest.NewServer(t, http.NewServer(""))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		path string
+		vary []string
+	}{
+		{"/rest/compress/text", []string{"Accept-Encoding"}},
+		{"/rest/compress/vary", []string{"accept-encoding"}},
+		{"/rest/compress/vary-origin", []string{"Origin", "Accept-Encoding"}},
+	} {
+		resp := ts.GET(tc.path).WithHeader("Accept-Encoding", "gzip").Expect().Raw()
+		var vary []string
+		resp.Header.VisitAll(func(k, v []byte) {
+			if string(k) == "Vary" {
+				vary = append(vary, string(v))
+			}
+		})
+		if strings.Join(vary, ",") != strings.Join(tc.vary, ",") {
+			t.Errorf("%s: Vary %q, want %q", tc.path, vary, tc.vary)
+		}
+	}
+}
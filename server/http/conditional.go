@@ -0,0 +1,206 @@
+package http
+
+import (
+	"bytes"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	strETag              = []byte("ETag")
+	strLastModified      = []byte("Last-Modified")
+	strIfMatch           = []byte("If-Match")
+	strIfNoneMatch       = []byte("If-None-Match")
+	strIfModifiedSince   = []byte("If-Modified-Since")
+	strIfUnmodifiedSince = []byte("If-Unmodified-Since")
+	strWeakPrefix        = []byte("W/")
+	strAnyETag           = []byte("*")
+)
+
+// ConditionalOptions configures the Conditional middleware.
+type ConditionalOptions struct {
+	// Weak makes generated ETags weak (W/"..."), for responses whose
+	// encoding may change while their meaning does not. Responses which
+	// may be compressed for the client get weak ETags anyway, which never
+	// match If-Match.
+	Weak bool
+
+	// CurrentETag returns the ETag of the resource a PUT, PATCH or DELETE
+	// request targets, ok is false when the resource does not exist. When
+	// set, If-Match is checked before the handler runs and a mismatch is
+	// answered with 412 Precondition Failed.
+	CurrentETag func(ctx *fasthttp.RequestCtx) (etag string, ok bool)
+}
+
+// Conditional returns a middleware which adds an ETag to successful GET and
+// HEAD responses that have none, and answers If-None-Match and
+// If-Modified-Since with 304 Not Modified. Handlers may set their own ETag
+// or Last-Modified with SetETag and SetLastModified.
+func Conditional(opts ConditionalOptions) Middleware {
+	return func(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if isUnsafeMethod(ctx) {
+				if opts.CurrentETag != nil && !checkPreconditions(ctx, opts.CurrentETag) {
+					Failed(ctx, fasthttp.StatusPreconditionFailed, "precondition failed")
+					return
+				}
+				h(ctx)
+				return
+			}
+
+			h(ctx)
+
+			if !ctx.IsGet() && !ctx.IsHead() {
+				return
+			}
+			code := ctx.Response.StatusCode()
+			if code < 200 || code >= 300 || ctx.Response.IsBodyStream() {
+				return
+			}
+
+			etag := ctx.Response.Header.PeekBytes(strETag)
+			if len(etag) == 0 && len(ctx.Response.Body()) > 0 {
+				SetETag(ctx, ComputeETag(ctx.Response.Body()), opts.Weak)
+				etag = ctx.Response.Header.PeekBytes(strETag)
+			}
+
+			if isNotModified(ctx, etag) {
+				// unlike ctx.NotModified keep the headers, a 304 carries
+				// the ETag, Cache-Control and Vary of the full response
+				ctx.Response.ResetBody()
+				ctx.SetStatusCode(fasthttp.StatusNotModified)
+			}
+		}
+	}
+}
+
+// ComputeETag returns the opaque tag for body, without quotes.
+func ComputeETag(body []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(body)
+	return strconv.FormatUint(uint64(len(body)), 16) + "-" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+// SetETag sets the ETag response header to the quoted tag, prefixed with W/
+// when weak.
+func SetETag(ctx *fasthttp.RequestCtx, tag string, weak bool) {
+	v := strconv.Quote(tag)
+	if weak {
+		v = "W/" + v
+	}
+	ctx.Response.Header.SetBytesK(strETag, v)
+}
+
+// SetLastModified sets the Last-Modified response header.
+func SetLastModified(ctx *fasthttp.RequestCtx, t time.Time) {
+	ctx.Response.Header.SetBytesKV(strLastModified, fasthttp.AppendHTTPDate(nil, t))
+}
+
+// CheckIfMatch checks the If-Match and If-Unmodified-Since preconditions of
+// the request against the current state of the resource and writes 412
+// Precondition Failed when they do not hold. It is meant for handlers which
+// can not be covered by ConditionalOptions.CurrentETag.
+func CheckIfMatch(ctx *fasthttp.RequestCtx, currentETag string, lastModified time.Time) bool {
+	ok := checkPreconditions(ctx, func(*fasthttp.RequestCtx) (string, bool) {
+		return currentETag, currentETag != "" || !lastModified.IsZero()
+	})
+	if ok && !lastModified.IsZero() {
+		ok = checkUnmodifiedSince(ctx, lastModified)
+	}
+	if !ok {
+		Failed(ctx, fasthttp.StatusPreconditionFailed, "precondition failed")
+	}
+	return ok
+}
+
+func isUnsafeMethod(ctx *fasthttp.RequestCtx) bool {
+	return ctx.IsPut() || ctx.IsPatch() || ctx.IsDelete()
+}
+
+func checkPreconditions(ctx *fasthttp.RequestCtx, current func(*fasthttp.RequestCtx) (string, bool)) bool {
+	ifMatch := ctx.Request.Header.PeekBytes(strIfMatch)
+	if len(ifMatch) == 0 {
+		return true
+	}
+	etag, exists := current(ctx)
+	if !exists {
+		return false
+	}
+	if bytes.Equal(bytes.TrimSpace(ifMatch), strAnyETag) {
+		return true
+	}
+	return matchETag(ifMatch, []byte(quoteETag(etag)), false)
+}
+
+func checkUnmodifiedSince(ctx *fasthttp.RequestCtx, lastModified time.Time) bool {
+	v := ctx.Request.Header.PeekBytes(strIfUnmodifiedSince)
+	if len(v) == 0 || len(ctx.Request.Header.PeekBytes(strIfMatch)) > 0 {
+		return true
+	}
+	t, err := fasthttp.ParseHTTPDate(v)
+	if err != nil {
+		return true
+	}
+	return !lastModified.Truncate(time.Second).After(t)
+}
+
+func isNotModified(ctx *fasthttp.RequestCtx, etag []byte) bool {
+	if ifNoneMatch := ctx.Request.Header.PeekBytes(strIfNoneMatch); len(ifNoneMatch) > 0 {
+		if len(etag) == 0 {
+			return false
+		}
+		if bytes.Equal(bytes.TrimSpace(ifNoneMatch), strAnyETag) {
+			return true
+		}
+		return matchETag(ifNoneMatch, etag, true)
+	}
+
+	ims := ctx.Request.Header.PeekBytes(strIfModifiedSince)
+	lm := ctx.Response.Header.PeekBytes(strLastModified)
+	if len(ims) == 0 || len(lm) == 0 {
+		return false
+	}
+	since, err := fasthttp.ParseHTTPDate(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := fasthttp.ParseHTTPDate(lm)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// matchETag reports whether etag is in the comma separated list header.
+// Weak comparison ignores the W/ prefix, strong comparison never matches a
+// weak tag.
+func matchETag(list, etag []byte, weak bool) bool {
+	if !weak && bytes.HasPrefix(etag, strWeakPrefix) {
+		return false
+	}
+	etag = bytes.TrimPrefix(etag, strWeakPrefix)
+	for _, candidate := range bytes.Split(list, []byte{','}) {
+		candidate = bytes.TrimSpace(candidate)
+		if bytes.HasPrefix(candidate, strWeakPrefix) {
+			if !weak {
+				continue
+			}
+			candidate = candidate[len(strWeakPrefix):]
+		}
+		if bytes.Equal(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return strconv.Quote(etag)
+}
@@ -0,0 +1,115 @@
+package http_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/zhlls/go-common/server/http"
+	"github.com/zhlls/go-common/server/http/httptest"
+)
+
+var pageModified = time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func init() {
+	page := http.Conditional(http.ConditionalOptions{})(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("text/plain; charset=utf-8")
+		http.SetLastModified(ctx, pageModified)
+		ctx.SetBody(largeText)
+	})
+	http.AddRouter(fasthttp.MethodGet, "/conditional/page", page)
+	http.AddRouter(fasthttp.MethodHead, "/conditional/page", page)
+	current := func(found bool) func(*fasthttp.RequestCtx) (string, bool) {
+		return func(*fasthttp.RequestCtx) (string, bool) { return "v1", found }
+	}
+	update := func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}
+	http.AddRouter(fasthttp.MethodPut, "/conditional/item",
+		http.Conditional(http.ConditionalOptions{CurrentETag: current(true)})(update))
+	http.AddRouter(fasthttp.MethodPut, "/conditional/missing",
+		http.Conditional(http.ConditionalOptions{CurrentETag: current(false)})(update))
+}
+
+func TestConditional(t *testing.T) {
+	ts := httptest.NewServer(t, http.NewServer(""))
+	defer ts.Close()
+
+	etag := `"` + http.ComputeETag(largeText) + `"`
+	ts.GET("/rest/conditional/page").Expect().Status(fasthttp.StatusOK).Header("ETag", etag)
+
+	since := fasthttp.AppendHTTPDate(nil, pageModified)
+	before := fasthttp.AppendHTTPDate(nil, pageModified.Add(-time.Hour))
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"none", "GET", "/rest/conditional/page", nil, fasthttp.StatusOK},
+		{"if-none-match", "GET", "/rest/conditional/page",
+			map[string]string{"If-None-Match": etag}, fasthttp.StatusNotModified},
+		{"if-none-match head", "HEAD", "/rest/conditional/page",
+			map[string]string{"If-None-Match": etag}, fasthttp.StatusNotModified},
+		{"if-none-match weak", "GET", "/rest/conditional/page",
+			map[string]string{"If-None-Match": "W/" + etag}, fasthttp.StatusNotModified},
+		{"if-none-match list", "GET", "/rest/conditional/page",
+			map[string]string{"If-None-Match": `"other", ` + etag}, fasthttp.StatusNotModified},
+		{"if-none-match other", "GET", "/rest/conditional/page",
+			map[string]string{"If-None-Match": `"other"`}, fasthttp.StatusOK},
+		{"if-none-match any", "GET", "/rest/conditional/page",
+			map[string]string{"If-None-Match": "*"}, fasthttp.StatusNotModified},
+		{"if-modified-since", "GET", "/rest/conditional/page",
+			map[string]string{"If-Modified-Since": string(since)}, fasthttp.StatusNotModified},
+		{"if-modified-since before", "GET", "/rest/conditional/page",
+			map[string]string{"If-Modified-Since": string(before)}, fasthttp.StatusOK},
+		{"if-none-match wins over if-modified-since", "GET", "/rest/conditional/page",
+			map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": string(since)}, fasthttp.StatusOK},
+		{"if-match", "PUT", "/rest/conditional/item",
+			map[string]string{"If-Match": `"v1"`}, fasthttp.StatusNoContent},
+		{"if-match weak", "PUT", "/rest/conditional/item",
+			map[string]string{"If-Match": `W/"v1"`}, fasthttp.StatusPreconditionFailed},
+		{"if-match other", "PUT", "/rest/conditional/item",
+			map[string]string{"If-Match": `"v2"`}, fasthttp.StatusPreconditionFailed},
+		{"if-match any", "PUT", "/rest/conditional/item",
+			map[string]string{"If-Match": "*"}, fasthttp.StatusNoContent},
+		{"no if-match", "PUT", "/rest/conditional/item", nil, fasthttp.StatusNoContent},
+		{"if-match missing", "PUT", "/rest/conditional/missing",
+			map[string]string{"If-Match": "*"}, fasthttp.StatusPreconditionFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := ts.NewRequest(tc.method, tc.path)
+			for k, v := range tc.headers {
+				req.WithHeader(k, v)
+			}
+			req.Expect().Status(tc.status)
+		})
+	}
+}
+
+func TestConditionalCompressedETag(t *testing.T) {
+	ts := httptest.NewServer(t, http.NewServer(""))
+	defer ts.Close()
+
+	etag := `"` + http.ComputeETag(largeText) + `"`
+	// the gzip bytes differ from the identity ones, the tag must not claim
+	// they are the same
+	ts.GET("/rest/conditional/page").
+		WithHeader("Accept-Encoding", "gzip").
+		Expect().
+		Status(fasthttp.StatusOK).
+		Header("Content-Encoding", "gzip").
+		Header("ETag", "W/"+etag)
+	ts.GET("/rest/conditional/page").
+		WithHeader("Accept-Encoding", "gzip").
+		WithHeader("If-None-Match", "W/"+etag).
+		Expect().
+		Status(fasthttp.StatusNotModified).
+		Header("ETag", "W/"+etag)
+	ts.HEAD("/rest/conditional/page").
+		WithHeader("Accept-Encoding", "gzip").
+		Expect().
+		Header("ETag", "W/"+etag)
+}
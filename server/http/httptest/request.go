@@ -0,0 +1,186 @@
+package httptest
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/zhlls/go-common/utils"
+)
+
+// Request is a request under construction, sent by Expect.
+type Request struct {
+	s   *Server
+	req *fasthttp.Request
+}
+
+// NewRequest starts building a request for method and path.
+func (s *Server) NewRequest(method, path string) *Request {
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://httptest" + path)
+	return &Request{s: s, req: req}
+}
+
+func (s *Server) GET(path string) *Request {
+	return s.NewRequest(fasthttp.MethodGet, path)
+}
+
+func (s *Server) HEAD(path string) *Request {
+	return s.NewRequest(fasthttp.MethodHead, path)
+}
+
+func (s *Server) POST(path string) *Request {
+	return s.NewRequest(fasthttp.MethodPost, path)
+}
+
+func (s *Server) PUT(path string) *Request {
+	return s.NewRequest(fasthttp.MethodPut, path)
+}
+
+func (s *Server) PATCH(path string) *Request {
+	return s.NewRequest(fasthttp.MethodPatch, path)
+}
+
+func (s *Server) DELETE(path string) *Request {
+	return s.NewRequest(fasthttp.MethodDelete, path)
+}
+
+// WithHeader sets a request header.
+func (r *Request) WithHeader(key, value string) *Request {
+	r.req.Header.Set(key, value)
+	return r
+}
+
+// WithQuery adds a query argument.
+func (r *Request) WithQuery(key, value string) *Request {
+	r.req.URI().QueryArgs().Add(key, value)
+	return r
+}
+
+// WithBody sets the raw request body.
+func (r *Request) WithBody(body []byte) *Request {
+	r.req.SetBody(body)
+	return r
+}
+
+// WithJSON marshals v as the request body and sets the content type.
+func (r *Request) WithJSON(v interface{}) *Request {
+	r.s.t.Helper()
+	data, err := utils.JsonMarshal(v)
+	if err != nil {
+		r.s.t.Fatalf("marshal request body: %v", err)
+	}
+	r.req.Header.SetContentTypeBytes(strApplicationJSON)
+	r.req.SetBody(data)
+	return r
+}
+
+// Raw gives access to the underlying request for anything not covered above.
+func (r *Request) Raw() *fasthttp.Request {
+	return r.req
+}
+
+// Expect sends the request and returns its response for assertions.
+func (r *Request) Expect() *Response {
+	r.s.t.Helper()
+	defer fasthttp.ReleaseRequest(r.req)
+
+	resp := &fasthttp.Response{}
+	if err := r.s.client.DoTimeout(r.req, resp, r.s.timeout); err != nil {
+		r.s.t.Fatalf("%s %s: %v", r.req.Header.Method(), r.req.URI().RequestURI(), err)
+	}
+	return &Response{s: r.s, resp: resp}
+}
+
+var strApplicationJSON = []byte("application/json")
+
+// Response is a received response, its assertion methods report failures
+// through the testing.TB of the Server and can be chained.
+type Response struct {
+	s    *Server
+	resp *fasthttp.Response
+}
+
+// Status asserts the response status code.
+func (r *Response) Status(code int) *Response {
+	r.s.t.Helper()
+	if got := r.resp.StatusCode(); got != code {
+		r.s.t.Errorf("status: got %d, want %d, body: %s", got, code, r.resp.Body())
+	}
+	return r
+}
+
+// Header asserts the value of a response header.
+func (r *Response) Header(key, value string) *Response {
+	r.s.t.Helper()
+	if got := string(r.resp.Header.Peek(key)); got != value {
+		r.s.t.Errorf("header %s: got %q, want %q", key, got, value)
+	}
+	return r
+}
+
+// HeaderExists asserts a response header is present.
+func (r *Response) HeaderExists(key string) *Response {
+	r.s.t.Helper()
+	if r.resp.Header.Peek(key) == nil {
+		r.s.t.Errorf("header %s: missing", key)
+	}
+	return r
+}
+
+// Body asserts the raw response body.
+func (r *Response) Body(body []byte) *Response {
+	r.s.t.Helper()
+	if got := r.resp.Body(); !bytes.Equal(got, body) {
+		r.s.t.Errorf("body: got %q, want %q", got, body)
+	}
+	return r
+}
+
+// JSON asserts the response body is JSON equal to v, ignoring formatting
+// and key order. v may be a value to marshal, a string or a []byte.
+func (r *Response) JSON(v interface{}) *Response {
+	r.s.t.Helper()
+	var want []byte
+	switch e := v.(type) {
+	case string:
+		want = []byte(e)
+	case []byte:
+		want = e
+	default:
+		data, err := utils.JsonMarshal(v)
+		if err != nil {
+			r.s.t.Fatalf("marshal expected body: %v", err)
+		}
+		want = data
+	}
+
+	var got, exp interface{}
+	if err := utils.JsonUnmarshal(r.resp.Body(), &got); err != nil {
+		r.s.t.Errorf("body is not json: %v, body: %s", err, r.resp.Body())
+		return r
+	}
+	if err := utils.JsonUnmarshal(want, &exp); err != nil {
+		r.s.t.Fatalf("expected body is not json: %v", err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		r.s.t.Errorf("json body: got %s, want %s", r.resp.Body(), want)
+	}
+	return r
+}
+
+// DecodeJSON unmarshals the response body into out.
+func (r *Response) DecodeJSON(out interface{}) *Response {
+	r.s.t.Helper()
+	if err := utils.JsonUnmarshal(r.resp.Body(), out); err != nil {
+		r.s.t.Errorf("decode json body: %v, body: %s", err, r.resp.Body())
+	}
+	return r
+}
+
+// Raw gives access to the underlying response.
+func (r *Response) Raw() *fasthttp.Response {
+	return r.resp
+}
@@ -0,0 +1,234 @@
+// Package httptest runs a server/http Server in-process for handler tests.
+//
+// The full router and middleware chain of the Server is served on an
+// in-memory listener, so requests go through exactly the same code as in
+// production without binding a port:
+//
+//	ts := httptest.NewServer(t, srv, httptest.CaptureLogs(log.DebugLevel), httptest.CaptureSpans())
+//	defer ts.Close()
+//
+//	ts.GET("/rest/demo").WithQuery("id", "1").Expect().
+//		Status(fasthttp.StatusOK).
+//		Header("Content-Type", "application/json").
+//		JSON(map[string]interface{}{"msg": "ok"})
+package httptest
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/zhlls/go-common/log"
+	"github.com/zhlls/go-common/server/http"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Option configures a test Server.
+type Option func(*Server)
+
+// CaptureLogs records every entry of the default log scope at or above
+// level. The scope output level is lowered to level while the server runs.
+func CaptureLogs(level log.Level) Option {
+	return func(s *Server) {
+		s.logLevel = level
+		s.captureLogs = true
+	}
+}
+
+// CaptureSpans installs a mock tracer as the global tracer while the server
+// runs, so finished spans can be inspected with FinishedSpans.
+func CaptureSpans() Option {
+	return func(s *Server) {
+		s.tracer = mocktracer.New()
+	}
+}
+
+// WithGatherer sets where metrics are read from, the default is
+// prometheus.DefaultGatherer which the metrics package registers with.
+func WithGatherer(g prometheus.Gatherer) Option {
+	return func(s *Server) {
+		s.gatherer = g
+	}
+}
+
+// WithTimeout sets the timeout of each request, the default is 10s.
+func WithTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.timeout = d
+	}
+}
+
+// Server is a server/http Server listening on an in-memory listener.
+type Server struct {
+	t   testing.TB
+	srv *http.Server
+
+	ln     *fasthttputil.InmemoryListener
+	client *fasthttp.Client
+	done   chan struct{}
+
+	timeout  time.Duration
+	gatherer prometheus.Gatherer
+
+	captureLogs bool
+	logLevel    log.Level
+	logs        *observer.ObservedLogs
+	restoreLogs []func()
+
+	tracer     *mocktracer.MockTracer
+	prevTracer opentracing.Tracer
+
+	closeOnce sync.Once
+}
+
+// NewServer starts srv on an in-memory listener. Call Close when done.
+func NewServer(t testing.TB, srv *http.Server, opts ...Option) *Server {
+	s := &Server{
+		t:        t,
+		srv:      srv,
+		ln:       fasthttputil.NewInmemoryListener(),
+		done:     make(chan struct{}),
+		timeout:  defaultTimeout,
+		gatherer: prometheus.DefaultGatherer,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.captureLogs {
+		scope := log.FindScope(log.DefaultScopeName)
+		prevLevel := scope.GetOutputLevel()
+		scope.SetOutputLevel(s.logLevel)
+
+		var core zapcore.Core
+		core, s.logs = observer.New(zapcore.DebugLevel)
+		detach := log.Observe(core)
+		s.restoreLogs = append(s.restoreLogs, detach, func() {
+			scope.SetOutputLevel(prevLevel)
+		})
+	}
+
+	if s.tracer != nil {
+		s.prevTracer = opentracing.GlobalTracer()
+		opentracing.SetGlobalTracer(mockTracer{s.tracer})
+	}
+
+	s.client = &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return s.ln.Dial()
+		},
+	}
+
+	go func() {
+		defer close(s.done)
+		_ = srv.Serve(s.ln)
+	}()
+
+	return s
+}
+
+// Close stops the server and restores the global logger and tracer.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.client.CloseIdleConnections()
+		s.srv.Stop()
+		_ = s.ln.Close()
+		<-s.done
+
+		for i := len(s.restoreLogs) - 1; i >= 0; i-- {
+			s.restoreLogs[i]()
+		}
+		if s.tracer != nil {
+			opentracing.SetGlobalTracer(s.prevTracer)
+		}
+	})
+}
+
+// Logs returns the entries captured so far, nil without CaptureLogs.
+func (s *Server) Logs() *observer.ObservedLogs {
+	return s.logs
+}
+
+// FinishedSpans returns the spans finished so far, nil without CaptureSpans.
+func (s *Server) FinishedSpans() []*mocktracer.MockSpan {
+	if s.tracer == nil {
+		return nil
+	}
+	return s.tracer.FinishedSpans()
+}
+
+// ResetSpans drops all spans recorded so far.
+func (s *Server) ResetSpans() {
+	if s.tracer != nil {
+		s.tracer.Reset()
+	}
+}
+
+// Metric returns the metric of family name whose labels contain labels,
+// or nil when there is none.
+func (s *Server) Metric(name string, labels map[string]string) *dto.Metric {
+	s.t.Helper()
+	mfs, err := s.gatherer.Gather()
+	if err != nil {
+		s.t.Fatalf("gather metrics: %v", err)
+		return nil
+	}
+
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if matchLabels(m.GetLabel(), labels) {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// MetricValue returns the value of a counter or gauge, the sample count of
+// a summary or histogram, and 0 when the metric does not exist.
+func (s *Server) MetricValue(name string, labels map[string]string) float64 {
+	s.t.Helper()
+	m := s.Metric(name, labels)
+	switch {
+	case m == nil:
+		return 0
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Summary != nil:
+		return float64(m.Summary.GetSampleCount())
+	case m.Histogram != nil:
+		return float64(m.Histogram.GetSampleCount())
+	case m.Untyped != nil:
+		return m.Untyped.GetValue()
+	}
+	return 0
+}
+
+func matchLabels(pairs []*dto.LabelPair, labels map[string]string) bool {
+	found := 0
+	for _, p := range pairs {
+		if v, ok := labels[p.GetName()]; ok {
+			if v != p.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
@@ -0,0 +1,63 @@
+package httptest_test
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/zhlls/go-common/log"
+	"github.com/zhlls/go-common/metrics"
+	"github.com/zhlls/go-common/server/http"
+	"github.com/zhlls/go-common/server/http/httptest"
+)
+
+func init() {
+	metrics.Init("httptest")
+	http.AddRouter(fasthttp.MethodGet, "/hello/{name}", func(ctx *fasthttp.RequestCtx) {
+		log.Info("hello handled")
+		http.OK(ctx, map[string]interface{}{"name": ctx.UserValue("name")})
+	})
+}
+
+func TestServerCapturesLogsSpansAndMetrics(t *testing.T) {
+	ts := httptest.NewServer(t, http.NewServer(""), httptest.CaptureLogs(log.DebugLevel), httptest.CaptureSpans())
+	defer ts.Close()
+
+	labels := map[string]string{"method": "GET", "endpoint": "/rest/hello/{name}", "status": "200"}
+	before := ts.MetricValue("httptest_api_request_total", labels)
+
+	ts.GET("/rest/hello/gopher").Expect().
+		Status(fasthttp.StatusOK).
+		JSON(map[string]interface{}{"name": "gopher"})
+
+	if n := ts.Logs().FilterMessage("hello handled").Len(); n != 1 {
+		t.Errorf("handler log: got %d entries, want 1", n)
+	}
+	if n := ts.Logs().FilterMessage("http request").Len(); n != 1 {
+		t.Errorf("request log: got %d entries, want 1", n)
+	}
+
+	spans := ts.FinishedSpans()
+	if len(spans) != 1 {
+		t.Fatalf("spans: got %d, want 1", len(spans))
+	}
+	if got, want := spans[0].OperationName, "GET /rest/hello/{name}"; got != want {
+		t.Errorf("span name: got %q, want %q", got, want)
+	}
+	if got := spans[0].Tag("http.status_code"); got != uint16(200) {
+		t.Errorf("span status: got %v, want 200", got)
+	}
+
+	if got := ts.MetricValue("httptest_api_request_total", labels) - before; got != 1 {
+		t.Errorf("request_total: got %v more, want 1", got)
+	}
+}
+
+func TestServerRestoresLogs(t *testing.T) {
+	ts := httptest.NewServer(t, http.NewServer(""), httptest.CaptureLogs(log.DebugLevel))
+	ts.Close()
+	log.Info("after close")
+	if n := ts.Logs().FilterMessage("after close").Len(); n != 0 {
+		t.Errorf("got %d entries after Close, want 0", n)
+	}
+}
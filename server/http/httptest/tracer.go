@@ -0,0 +1,78 @@
+package httptest
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+
+	"github.com/zhlls/go-common/tracing"
+)
+
+// mockTracer wraps the mock tracer so the contexts of its spans implement
+// tracing.IDSpanContext, which the trace response headers and the log
+// fields of the server rely on.
+type mockTracer struct {
+	*mocktracer.MockTracer
+}
+
+func (t mockTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	return mockSpan{t.MockTracer.StartSpan(operationName, append(opts, unwrapReferences{})...), t}
+}
+
+func (t mockTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	return t.MockTracer.Inject(unwrapContext(sc), format, carrier)
+}
+
+func (t mockTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	sc, err := t.MockTracer.Extract(format, carrier)
+	if err != nil {
+		return nil, err
+	}
+	return mockContext{sc.(mocktracer.MockSpanContext)}, nil
+}
+
+type mockSpan struct {
+	opentracing.Span
+	tracer mockTracer
+}
+
+func (s mockSpan) Context() opentracing.SpanContext {
+	return mockContext{s.Span.Context().(mocktracer.MockSpanContext)}
+}
+
+func (s mockSpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+type mockContext struct {
+	mocktracer.MockSpanContext
+}
+
+var _ tracing.IDSpanContext = mockContext{}
+
+func (c mockContext) TraceID() (high, low uint64) {
+	return 0, uint64(c.MockSpanContext.TraceID)
+}
+
+func (c mockContext) SpanID() uint64 {
+	return uint64(c.MockSpanContext.SpanID)
+}
+
+func (c mockContext) IsSampled() bool {
+	return c.Sampled
+}
+
+func unwrapContext(sc opentracing.SpanContext) opentracing.SpanContext {
+	if c, ok := sc.(mockContext); ok {
+		return c.MockSpanContext
+	}
+	return sc
+}
+
+// unwrapReferences hands the mock tracer the span contexts it knows.
+type unwrapReferences struct{}
+
+func (unwrapReferences) Apply(o *opentracing.StartSpanOptions) {
+	for i, ref := range o.References {
+		o.References[i].ReferencedContext = unwrapContext(ref.ReferencedContext)
+	}
+}
@@ -37,17 +37,17 @@ func logMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 
 			end := time.Now()
 			latency := end.Sub(start)
-			clientIP := ctx.RemoteIP().String()
+			clientIP := ClientIP(ctx).String()
 			method := ctx.Method()
 			statusCode := ctx.Response.StatusCode()
-			log.Debug("http request",
+			log.Ctx(GetTraceContext(ctx)).Debug("http request",
 				zap.Time("end", end),
 				zap.Int("status", statusCode),
 				zap.Duration("latency", latency),
 				zap.String("client", clientIP),
 				zap.ByteString("method", method),
 				zap.ByteString("path", raw),
-				zap.Int("response-size", len(ctx.Response.Body())),
+				zap.Int("response-size", responseSize(&ctx.Response)),
 			)
 		}
 	}
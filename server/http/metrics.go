@@ -10,13 +10,22 @@ import (
 	"github.com/zhlls/go-common/utils"
 )
 
+const routerPathKey = "__router_path__"
+
 func routerPathPrepare(path string, h fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		ctx.SetUserValue("__router_path__", path)
+		ctx.SetUserValue(routerPathKey, path)
 		h(ctx)
 	}
 }
 
+// RouterPath returns the registered route pattern which matched the request,
+// e.g. "/rest/users/{id}", or "" when no route matched.
+func RouterPath(ctx *fasthttp.RequestCtx) string {
+	path, _ := ctx.UserValue(routerPathKey).(string)
+	return path
+}
+
 func metricsMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		// Start timer
@@ -38,11 +47,7 @@ func metricsMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 
 		latency := time.Since(start)
 
-		var fullPath string
-		v := ctx.UserValue("__router_path__")
-		if v != nil {
-			fullPath = v.(string)
-		}
+		fullPath := RouterPath(ctx)
 		if fullPath == "" {
 			fullPath = string(ctx.Path())
 		}
@@ -67,10 +72,12 @@ func metricsMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 			fullPath,
 			strconv.Itoa(ctx.Response.StatusCode()),
 			latency.Seconds()*1000)
-		metrics.CollectAPIResponseSize(
-			string(ctx.Method()),
-			fullPath,
-			strconv.Itoa(ctx.Response.StatusCode()),
-			float64(len(ctx.Response.Body())))
+		if size := responseSize(&ctx.Response); size >= 0 {
+			metrics.CollectAPIResponseSize(
+				string(ctx.Method()),
+				fullPath,
+				strconv.Itoa(ctx.Response.StatusCode()),
+				float64(size))
+		}
 	}
 }
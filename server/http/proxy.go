@@ -0,0 +1,261 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	clientIPKey     = "__client_ip__"
+	clientSchemeKey = "__client_scheme__"
+)
+
+// DefaultClientIPHeader is the header the trusted proxies are expected to
+// write the client address to, see SetClientIPHeader.
+const DefaultClientIPHeader = "X-Forwarded-For"
+
+var (
+	strForwarded       = []byte("Forwarded")
+	strXForwardedProto = []byte("X-Forwarded-Proto")
+)
+
+// SetTrustedProxies sets the addresses of the proxies in front of the
+// server, as CIDRs or single IPs. Forwarding headers are only honoured on
+// connections from these addresses, by default no proxy is trusted.
+func (s *Server) SetTrustedProxies(proxies ...string) error {
+	nets, err := ParseCIDRs(proxies...)
+	if err != nil {
+		return err
+	}
+	s.trustedProxies = nets
+	return nil
+}
+
+// SetClientIPHeader sets the header the trusted proxies write the client
+// address to: X-Forwarded-For (the default), Forwarded, X-Real-IP or any
+// header carrying a comma separated list of addresses. Other forwarding
+// headers are ignored, as clients can send them through the proxy.
+func (s *Server) SetClientIPHeader(name string) {
+	s.clientIPHeader = name
+}
+
+// ParseCIDRs parses CIDRs, single IPs are taken as /32 or /128 networks.
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", c)
+			}
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %v", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ClientIP returns the address of the client which sent the request, taken
+// from the forwarding headers when the request came through trusted proxies.
+func ClientIP(ctx *fasthttp.RequestCtx) net.IP {
+	if ip, ok := ctx.UserValue(clientIPKey).(net.IP); ok {
+		return ip
+	}
+	return ctx.RemoteIP()
+}
+
+// ClientScheme returns the scheme the client used, "http" or "https", taken
+// from the forwarding headers when the request came through trusted proxies.
+func ClientScheme(ctx *fasthttp.RequestCtx) string {
+	if scheme, ok := ctx.UserValue(clientSchemeKey).(string); ok {
+		return scheme
+	}
+	if ctx.IsTLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// AllowIPs returns a middleware which answers 403 to clients whose ClientIP
+// is not within cidrs.
+func AllowIPs(cidrs ...string) (Middleware, error) {
+	nets, err := ParseCIDRs(cidrs...)
+	if err != nil {
+		return nil, err
+	}
+	return func(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if !containsIP(nets, ClientIP(ctx)) {
+				Failed(ctx, fasthttp.StatusForbidden, "forbidden")
+				return
+			}
+			h(ctx)
+		}
+	}, nil
+}
+
+func (s *Server) realIPMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	trusted := s.trustedProxies
+	if len(trusted) == 0 {
+		return h
+	}
+	name := s.clientIPHeader
+	if name == "" {
+		name = DefaultClientIPHeader
+	}
+	header := []byte(name)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		remote := ctx.RemoteIP()
+		if containsIP(trusted, remote) {
+			ip, scheme := forwardedClient(&ctx.Request.Header, header, trusted, remote)
+			ctx.SetUserValue(clientIPKey, ip)
+			if scheme != "" {
+				ctx.SetUserValue(clientSchemeKey, scheme)
+			}
+		}
+		h(ctx)
+	}
+}
+
+// forwardedClient walks the proxy chain in the client IP header from the
+// nearest hop and returns the first address which is not a trusted proxy.
+// The walk stops at a hop which cannot be parsed and returns the last
+// trusted hop, remote when there is none. Repeated headers are taken as one
+// list, as a proxy may add its own header instead of appending.
+func forwardedClient(header *fasthttp.RequestHeader, name []byte, trusted []*net.IPNet, remote net.IP) (net.IP, string) {
+	var hops []net.IP
+	var protos []string
+
+	v := headerValues(header, name)
+	if bytes.EqualFold(name, strForwarded) {
+		hops, protos = parseForwarded(v)
+	} else {
+		if len(v) > 0 {
+			for _, part := range bytes.Split(v, []byte{','}) {
+				hops = append(hops, parseHostIP(string(bytes.TrimSpace(part))))
+			}
+		}
+		if proto := headerValues(header, strXForwardedProto); len(proto) > 0 {
+			for _, part := range bytes.Split(proto, []byte{','}) {
+				protos = append(protos, strings.ToLower(string(bytes.TrimSpace(part))))
+			}
+		}
+	}
+
+	client := remote
+	clientIdx := -1
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == nil {
+			break
+		}
+		client, clientIdx = hops[i], i
+		if !containsIP(trusted, hops[i]) {
+			break
+		}
+	}
+
+	var scheme string
+	if len(protos) > 0 {
+		// every proxy appends the proto of its incoming connection, so the
+		// protos line up with the hops from the right and those left of
+		// the client's were sent by the client; with fewer protos than
+		// hops only the outermost proxy sets it
+		i := len(protos) - 1
+		if clientIdx >= 0 {
+			i -= len(hops) - 1 - clientIdx
+		}
+		if i < 0 {
+			i = 0
+		}
+		scheme = protos[i]
+	}
+	if scheme != "http" && scheme != "https" {
+		scheme = ""
+	}
+	return client, scheme
+}
+
+// headerValues returns the values of all headers called name, joined by
+// commas.
+func headerValues(header *fasthttp.RequestHeader, name []byte) []byte {
+	var v []byte
+	header.VisitAll(func(key, value []byte) {
+		if !bytes.EqualFold(key, name) {
+			return
+		}
+		if len(v) > 0 {
+			v = append(v, ',')
+		}
+		v = append(v, value...)
+	})
+	return v
+}
+
+// parseForwarded returns the for= and proto= parameters of every element
+// of an RFC 7239 Forwarded header.
+func parseForwarded(v []byte) ([]net.IP, []string) {
+	var hops []net.IP
+	var protos []string
+	if len(v) == 0 {
+		return nil, nil
+	}
+	for _, element := range strings.Split(string(v), ",") {
+		var ip net.IP
+		var proto string
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			switch strings.ToLower(kv[0]) {
+			case "for":
+				ip = parseHostIP(value)
+			case "proto":
+				proto = strings.ToLower(value)
+			}
+		}
+		hops = append(hops, ip)
+		protos = append(protos, proto)
+	}
+	return hops, protos
+}
+
+// parseHostIP parses an address which may carry a port or, for IPv6, be
+// enclosed in brackets. Obfuscated and unknown identifiers yield nil.
+func parseHostIP(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
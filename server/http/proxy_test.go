@@ -0,0 +1,124 @@
+package http
+
+import (
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestForwardedClient(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := net.ParseIP("10.0.0.1")
+
+	for _, tc := range []struct {
+		name    string
+		header  string
+		headers map[string]string
+		added   [][2]string
+		ip      string
+		scheme  string
+	}{
+		{
+			name:    "x-forwarded-for",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.2", "X-Forwarded-Proto": "https"},
+			ip:      "203.0.113.7",
+			scheme:  "https",
+		},
+		{
+			name:    "client forwarded header is ignored",
+			headers: map[string]string{"Forwarded": "for=192.0.2.1", "X-Forwarded-For": "203.0.113.7"},
+			ip:      "203.0.113.7",
+		},
+		{
+			name:    "spoofed hops before the client",
+			headers: map[string]string{"X-Forwarded-For": "192.0.2.1, 203.0.113.7, 10.0.0.2"},
+			ip:      "203.0.113.7",
+		},
+		{
+			name:  "repeated header of a proxy which does not append",
+			added: [][2]string{{"X-Forwarded-For", "192.0.2.1"}, {"X-Forwarded-For", "203.0.113.7, 10.0.0.2"}},
+			ip:    "203.0.113.7",
+		},
+		{
+			name:    "spoofed proto before the proxy's",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "https, http"},
+			ip:      "203.0.113.7",
+			scheme:  "http",
+		},
+		{
+			name:    "spoofed hop and proto",
+			headers: map[string]string{"X-Forwarded-For": "192.0.2.1, 203.0.113.7", "X-Forwarded-Proto": "https, http"},
+			ip:      "203.0.113.7",
+			scheme:  "http",
+		},
+		{
+			name: "repeated proto header",
+			added: [][2]string{
+				{"X-Forwarded-For", "203.0.113.7"},
+				{"X-Forwarded-Proto", "https"},
+				{"X-Forwarded-Proto", "http"},
+			},
+			ip:     "203.0.113.7",
+			scheme: "http",
+		},
+		{
+			name:    "proto per hop",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.2", "X-Forwarded-Proto": "https, http"},
+			ip:      "203.0.113.7",
+			scheme:  "https",
+		},
+		{
+			name:    "unparsable hop returns last trusted hop",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7, garbage, 10.0.0.2"},
+			ip:      "10.0.0.2",
+		},
+		{
+			name:    "unparsable nearest hop returns remote",
+			headers: map[string]string{"X-Forwarded-For": "garbage"},
+			ip:      "10.0.0.1",
+		},
+		{
+			name:    "no header",
+			headers: map[string]string{},
+			ip:      "10.0.0.1",
+		},
+		{
+			name:    "configured forwarded header",
+			header:  "Forwarded",
+			headers: map[string]string{"Forwarded": `for=203.0.113.7;proto=https, for="10.0.0.2"`, "X-Forwarded-For": "192.0.2.1"},
+			ip:      "203.0.113.7",
+			scheme:  "https",
+		},
+		{
+			name:    "configured x-real-ip header",
+			header:  "X-Real-IP",
+			headers: map[string]string{"X-Real-IP": "203.0.113.7", "X-Forwarded-For": "192.0.2.1"},
+			ip:      "203.0.113.7",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var h fasthttp.RequestHeader
+			for k, v := range tc.headers {
+				h.Set(k, v)
+			}
+			for _, kv := range tc.added {
+				h.Add(kv[0], kv[1])
+			}
+			name := tc.header
+			if name == "" {
+				name = DefaultClientIPHeader
+			}
+			ip, scheme := forwardedClient(&h, []byte(name), trusted, remote)
+			if ip.String() != tc.ip {
+				t.Errorf("ip: got %s, want %s", ip, tc.ip)
+			}
+			if scheme != tc.scheme {
+				t.Errorf("scheme: got %q, want %q", scheme, tc.scheme)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package http
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/valyala/fasthttp"
 
 	"github.com/zhlls/go-common/log"
@@ -24,6 +28,16 @@ func recoveryHandler(handler ...PanicHandler) PanicHandler {
 		)
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 
+		if sp := opentracing.SpanFromContext(GetTraceContext(ctx)); sp != nil {
+			ext.Error.Set(sp, true)
+			sp.LogFields(
+				otlog.String("event", "error"),
+				otlog.String("error.kind", "panic"),
+				otlog.String("message", fmt.Sprint(info)),
+				otlog.String("stack", string(stack)),
+			)
+		}
+
 		for _, h := range handler {
 			if h == nil {
 				continue
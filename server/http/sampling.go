@@ -0,0 +1,156 @@
+package http
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaegerUtils "github.com/uber/jaeger-client-go/utils"
+)
+
+const (
+	SamplingSampled = "sampled"
+	SamplingDropped = "dropped"
+
+	// reasons of a sampling decision, as reported by the trace spans metric
+	samplingReasonRate     = "rate"
+	samplingReasonRoute    = "route"
+	samplingReasonLimit    = "limit"
+	samplingReasonUpstream = "upstream"
+	samplingReasonError    = "error"
+	samplingReasonSlow     = "slow"
+)
+
+// SamplingOptions configures which requests are traced. The decision is
+// passed to the tracer with the sampling.priority tag, so the tracer itself
+// should sample everything, e.g. a jaeger const sampler with param 1.
+type SamplingOptions struct {
+	// Rate is the probability, from 0 to 1, that a request which matches
+	// none of Routes is traced.
+	Rate float64
+
+	// Routes override Rate per route, the first matching route wins.
+	Routes []RouteSampling
+
+	// PerSecond caps the traces started per second by Rate and Routes,
+	// zero means no limit.
+	PerSecond float64
+
+	// HonourUpstream keeps the sampling decision of callers which send a
+	// trace context, instead of deciding again.
+	HonourUpstream bool
+
+	// Errors traces every request answered with a 5xx status.
+	Errors bool
+
+	// SlowerThan traces every request taking longer, zero disables it.
+	SlowerThan time.Duration
+}
+
+// RouteSampling is the sampling rate of requests matching a route.
+type RouteSampling struct {
+	// Route is a pattern in router syntax matched against the request path,
+	// e.g. "/rest/users/{id}" or "/assets/{filepath:*}".
+	Route string
+
+	// Rate is the probability, from 0 to 1, that a matching request is traced.
+	Rate float64
+}
+
+// SetSampling makes the server decide which requests are traced. Errors
+// and slow requests are decided when the request finishes, their server
+// span is kept even if child spans which finished earlier were dropped.
+func (s *Server) SetSampling(opts SamplingOptions) {
+	s.sampling = &opts
+}
+
+type sampler struct {
+	opts    SamplingOptions
+	limiter jaegerUtils.RateLimiter
+}
+
+func newSampler(opts SamplingOptions) *sampler {
+	sm := &sampler{opts: opts}
+	if opts.PerSecond > 0 {
+		sm.limiter = jaegerUtils.NewRateLimiter(opts.PerSecond, maxFloat(opts.PerSecond, 1))
+	}
+	return sm
+}
+
+// head decides whether a new trace is sampled when the request starts.
+func (sm *sampler) head(path string) (bool, string) {
+	rate, reason := sm.opts.Rate, samplingReasonRate
+	for _, r := range sm.opts.Routes {
+		if matchRoute(r.Route, path) {
+			rate, reason = r.Rate, samplingReasonRoute
+			break
+		}
+	}
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return false, reason
+	}
+	if sm.limiter != nil && !sm.limiter.CheckCredit(1) {
+		return false, samplingReasonLimit
+	}
+	return true, reason
+}
+
+// tail decides whether a request not sampled so far is sampled after all.
+func (sm *sampler) tail(statusCode int, elapsed time.Duration) (bool, string) {
+	if sm.opts.Errors && statusCode >= 500 {
+		return true, samplingReasonError
+	}
+	if sm.opts.SlowerThan > 0 && elapsed >= sm.opts.SlowerThan {
+		return true, samplingReasonSlow
+	}
+	return false, ""
+}
+
+func samplingPriority(sampled bool) opentracing.StartSpanOption {
+	var priority uint16
+	if sampled {
+		priority = 1
+	}
+	return opentracing.Tag{Key: string(ext.SamplingPriority), Value: priority}
+}
+
+func samplingDecision(sampled bool) string {
+	if sampled {
+		return SamplingSampled
+	}
+	return SamplingDropped
+}
+
+// matchRoute reports whether path matches a route pattern, where "{name}"
+// matches a single segment and "{name:*}" the rest of the path.
+func matchRoute(pattern, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range ps {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, ":*}") {
+			return true
+		}
+		if i >= len(segs) {
+			return false
+		}
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segs[i] == "" {
+				return false
+			}
+			continue
+		}
+		if p != segs[i] {
+			return false
+		}
+	}
+	return len(ps) == len(segs)
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -31,7 +31,18 @@ type Server struct {
 	disableTrace bool
 	enableSentry bool
 
+	traceResponseHeaders bool
+
 	panicHandlers []PanicHandler
+
+	statics []staticMount
+
+	compression   CompressionOptions
+	decompression DecompressionOptions
+
+	trustedProxies []*net.IPNet
+	clientIPHeader string
+	sampling       *SamplingOptions
 }
 
 //func timeoutMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
@@ -62,6 +73,33 @@ func (s *Server) Start() error {
 		}
 	}()
 
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		log.Error("http: listen addr failed",
+			zap.String("addr", s.addr),
+			zap.Error(err))
+		return err
+	}
+	log.Info("http server listening at " + s.addr)
+
+	return s.Serve(lis)
+}
+
+// Serve builds the handler chain and serves incoming connections from lis.
+func (s *Server) Serve(lis net.Listener) error {
+	s.http.Handler = s.Handler()
+
+	if err := s.http.Serve(lis); err != nil {
+		log.Error("Error in http Serve", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// Handler returns the router of the server wrapped in its middleware chain,
+// exactly as it is served by Start.
+func (s *Server) Handler() fasthttp.RequestHandler {
 	// router
 	router := fasthttprouter.New()
 	router.PanicHandler = recoveryHandler(s.panicHandlers...)
@@ -88,23 +126,17 @@ func (s *Server) Start() error {
 		router.Handle(ri.method, fullPath, handle)
 	}
 
-	s.http.Handler = s.finallyHandler(router)
-
-	lis, err := net.Listen("tcp", s.addr)
-	if err != nil {
-		log.Error("http: listen addr failed",
-			zap.String("addr", s.addr),
-			zap.Error(err))
-		return err
+	for _, m := range s.statics {
+		fullPath := m.prefix + "/{filepath:*}"
+		handle := routerPathPrepare(fullPath, s.staticHandler(m))
+		router.GET(fullPath, handle)
+		router.HEAD(fullPath, handle)
+		// without it other methods get 405 for every path below the
+		// mount, for unknown API routes below a "/" mount as well
+		router.ANY(fullPath, s.notFoundHandler())
 	}
-	log.Info("http server listening at " + s.addr)
 
-	if err := s.http.Serve(lis); err != nil {
-		log.Error("Error in http Serve", zap.Error(err))
-		return err
-	}
-
-	return nil
+	return s.finallyHandler(router)
 }
 
 func (s *Server) Stop() {
@@ -139,18 +171,18 @@ func (s *Server) finallyHandler(router *fasthttprouter.Router) fasthttp.RequestH
 
 func NewServer(addr string) *Server {
 	s := &Server{
-		addr:       addr,
-		pathPrefix: "/rest",
-		middleware: []Middleware{
-			metricsMiddleware,
-			logMiddleware,
-			// timeoutMiddleware,
-			fasthttp.CompressHandler,
-		},
+		addr:        addr,
+		pathPrefix:  "/rest",
+		compression: DefaultCompressionOptions(),
 	}
-
-	if !s.disableTrace {
-		s.middleware = append([]Middleware{tracerMiddleware}, s.middleware...)
+	s.middleware = []Middleware{
+		s.realIPMiddleware,
+		s.tracerMiddleware,
+		metricsMiddleware,
+		logMiddleware,
+		// timeoutMiddleware,
+		s.compressMiddleware,
+		s.decompressMiddleware,
 	}
 
 	s.http = &fasthttp.Server{
@@ -0,0 +1,356 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
+)
+
+const defaultIndexFile = "index.html"
+
+var (
+	strAcceptRanges   = []byte("Accept-Ranges")
+	strBytes          = []byte("bytes")
+	strRange          = []byte("Range")
+	strCacheControl   = []byte("Cache-Control")
+	strVary           = []byte("Vary")
+	strAcceptEncoding = []byte("Accept-Encoding")
+	strBr             = []byte("br")
+	strNoCache        = []byte("no-cache")
+)
+
+// StaticOptions configures a filesystem mounted with Server.Static.
+type StaticOptions struct {
+	// Root is the filesystem to serve, e.g. http.Dir("./ui") or
+	// http.FS(embedded) for an embed.FS.
+	Root http.FileSystem
+
+	// IndexFile is served for directories, defaults to index.html.
+	IndexFile string
+
+	// MaxAge sets Cache-Control max-age of served files, zero sends no-cache.
+	// The SPA index is always sent with no-cache.
+	MaxAge time.Duration
+
+	// Precompressed serves name.br or name.gz instead of name when they
+	// exist and the client accepts the encoding.
+	Precompressed bool
+
+	// Browse enables directory listing for directories without index file.
+	Browse bool
+
+	// SPA serves the index file for unknown paths without extension, so
+	// client side routes of single page applications can be reloaded.
+	SPA bool
+
+	// APIPrefixes are never answered by the SPA fallback, unknown paths
+	// below them get the NotFound handler of the Server instead. The path
+	// prefix of the Server is always included.
+	APIPrefixes []string
+
+	// Dotfiles serves files and directories whose name starts with a dot,
+	// like .git or .env, which are not found by default.
+	Dotfiles bool
+}
+
+type staticMount struct {
+	prefix string
+	opts   StaticOptions
+}
+
+// Static mounts opts.Root at prefix. Files are served for GET and HEAD with
+// Last-Modified, Cache-Control and single byte range support, other methods
+// get the NotFound handler unless a route matches.
+func (s *Server) Static(prefix string, opts StaticOptions) {
+	if opts.IndexFile == "" {
+		opts.IndexFile = defaultIndexFile
+	}
+	s.statics = append(s.statics, staticMount{
+		prefix: strings.TrimSuffix(prefix, "/"),
+		opts:   opts,
+	})
+}
+
+func (s *Server) staticHandler(m staticMount) fasthttp.RequestHandler {
+	apiPrefixes := m.opts.APIPrefixes
+	if s.pathPrefix != "" {
+		apiPrefixes = append([]string{s.pathPrefix}, apiPrefixes...)
+	}
+	sh := &staticHandler{
+		opts:        m.opts,
+		apiPrefixes: apiPrefixes,
+		notFound:    s.notFoundHandler(),
+	}
+	return sh.handle
+}
+
+// notFoundHandler returns the NotFound handler of the server, or
+// JsonNotFoundHandler when it has none.
+func (s *Server) notFoundHandler() fasthttp.RequestHandler {
+	if s.NotFound != nil {
+		return s.NotFound
+	}
+	return JsonNotFoundHandler
+}
+
+type staticHandler struct {
+	opts        StaticOptions
+	apiPrefixes []string
+	notFound    fasthttp.RequestHandler
+}
+
+func (sh *staticHandler) handle(ctx *fasthttp.RequestCtx) {
+	var name string
+	if v, ok := ctx.UserValue("filepath").(string); ok {
+		name = v
+	}
+	name = path.Clean("/" + name)
+	if !sh.opts.Dotfiles && hasDotfile(name) {
+		sh.notFound(ctx)
+		return
+	}
+
+	f, fi, err := sh.open(name)
+	if err != nil {
+		sh.fallback(ctx, name)
+		return
+	}
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+
+	if fi.IsDir() {
+		uri := ctx.Path()
+		if len(uri) == 0 || uri[len(uri)-1] != '/' {
+			ctx.RedirectBytes(append(uri, '/'), fasthttp.StatusMovedPermanently)
+			return
+		}
+
+		index := path.Join(name, sh.opts.IndexFile)
+		indexFile, indexInfo, err := sh.open(index)
+		if err == nil && !indexInfo.IsDir() {
+			_ = f.Close()
+			f = nil
+			sh.serveFile(ctx, index, indexFile, indexInfo, false)
+			return
+		}
+		if sh.opts.Browse {
+			sh.serveDir(ctx, name, f)
+			return
+		}
+		sh.fallback(ctx, name)
+		return
+	}
+
+	sh.serveFile(ctx, name, f, fi, false)
+	f = nil
+}
+
+func (sh *staticHandler) open(name string) (http.File, os.FileInfo, error) {
+	f, err := sh.opts.Root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// fallback answers paths without a file: the NotFound handler for API
+// prefixes and paths with an extension, the index file for SPA routes.
+func (sh *staticHandler) fallback(ctx *fasthttp.RequestCtx, name string) {
+	if sh.opts.SPA && !sh.isAPIPath(ctx) && path.Ext(name) == "" {
+		index := "/" + sh.opts.IndexFile
+		f, fi, err := sh.open(index)
+		if err == nil && !fi.IsDir() {
+			sh.serveFile(ctx, index, f, fi, true)
+			return
+		}
+		if err == nil {
+			_ = f.Close()
+		}
+	}
+
+	sh.notFound(ctx)
+}
+
+// hasDotfile reports whether an element of the cleaned path name starts
+// with a dot.
+func hasDotfile(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (sh *staticHandler) isAPIPath(ctx *fasthttp.RequestCtx) bool {
+	p := string(ctx.Path())
+	for _, prefix := range sh.apiPrefixes {
+		if p == prefix || strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveFile writes f to the response and takes ownership of it.
+func (sh *staticHandler) serveFile(ctx *fasthttp.RequestCtx, name string, f http.File, fi os.FileInfo, spa bool) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if spa || sh.opts.MaxAge <= 0 {
+		ctx.Response.Header.SetBytesKV(strCacheControl, strNoCache)
+	} else {
+		ctx.Response.Header.SetBytesK(strCacheControl,
+			"public, max-age="+strconv.Itoa(int(sh.opts.MaxAge.Seconds())))
+	}
+
+	modTime := fi.ModTime()
+	if !modTime.IsZero() {
+		SetLastModified(ctx, modTime)
+		if ims := ctx.Request.Header.PeekBytes(strIfModifiedSince); len(ims) > 0 {
+			if since, err := fasthttp.ParseHTTPDate(ims); err == nil && !modTime.Truncate(time.Second).After(since) {
+				_ = f.Close()
+				ctx.NotModified()
+				return
+			}
+		}
+	}
+
+	byteRange := ctx.Request.Header.PeekBytes(strRange)
+	if sh.opts.Precompressed && len(byteRange) == 0 {
+		if cf, cfi, encoding := sh.openCompressed(ctx, name); cf != nil {
+			_ = f.Close()
+			f, fi = cf, cfi
+			ctx.Response.Header.SetBytesKV(StrContentEncoding, encoding)
+		}
+		addVary(&ctx.Response.Header, strAcceptEncoding)
+	}
+
+	ctx.SetContentType(contentType)
+	ctx.Response.Header.SetBytesKV(strAcceptRanges, strBytes)
+
+	size := int(fi.Size())
+	start, end := 0, size-1
+	if len(byteRange) > 0 && size > 0 {
+		var err error
+		start, end, err = fasthttp.ParseByteRange(byteRange, size)
+		if err != nil {
+			_ = f.Close()
+			ctx.Response.Header.Set("Content-Range", "bytes */"+strconv.Itoa(size))
+			ctx.SetStatusCode(fasthttp.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if _, err := f.Seek(int64(start), io.SeekStart); err != nil {
+			_ = f.Close()
+			log.Error("static: seek failed", zap.String("name", name), zap.Error(err))
+			ctx.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError), fasthttp.StatusInternalServerError)
+			return
+		}
+		ctx.Response.Header.SetContentRange(start, end, size)
+		ctx.SetStatusCode(fasthttp.StatusPartialContent)
+	} else {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	}
+
+	n := end - start + 1
+	if ctx.IsHead() {
+		_ = f.Close()
+		ctx.Response.SkipBody = true
+		ctx.Response.Header.SetContentLength(n)
+		return
+	}
+	ctx.SetBodyStream(&limitedFile{Reader: io.LimitReader(f, int64(n)), f: f}, n)
+}
+
+func (sh *staticHandler) openCompressed(ctx *fasthttp.RequestCtx, name string) (http.File, os.FileInfo, []byte) {
+	candidates := []struct {
+		encoding []byte
+		suffix   string
+	}{
+		{strBr, ".br"},
+		{StrGzip, ".gz"},
+	}
+	accepted := acceptedEncodings(ctx)
+	for _, c := range candidates {
+		if accepted.q(string(c.encoding)) <= 0 {
+			continue
+		}
+		f, fi, err := sh.open(name + c.suffix)
+		if err != nil {
+			continue
+		}
+		if fi.IsDir() {
+			_ = f.Close()
+			continue
+		}
+		return f, fi, c.encoding
+	}
+	return nil, nil, nil
+}
+
+func (sh *staticHandler) serveDir(ctx *fasthttp.RequestCtx, name string, f http.File) {
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		log.Error("static: read dir failed", zap.String("name", name), zap.Error(err))
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError), fasthttp.StatusInternalServerError)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	var buf bytes.Buffer
+	title := html.EscapeString(name)
+	fmt.Fprintf(&buf, "<html><head><title>%s</title></head><body><h1>%s</h1><ul>", title, title)
+	if name != "/" {
+		buf.WriteString(`<li><a href="../">..</a></li>`)
+	}
+	for _, fi := range infos {
+		n := fi.Name()
+		if !sh.opts.Dotfiles && strings.HasPrefix(n, ".") {
+			continue
+		}
+		if fi.IsDir() {
+			n += "/"
+		}
+		fmt.Fprintf(&buf, `<li><a href="%s">%s</a></li>`, html.EscapeString(n), html.EscapeString(n))
+	}
+	buf.WriteString("</ul></body></html>")
+
+	ctx.Response.Header.SetBytesKV(strCacheControl, strNoCache)
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.SetBody(buf.Bytes())
+}
+
+type limitedFile struct {
+	io.Reader
+	f http.File
+}
+
+func (lf *limitedFile) Close() error {
+	return lf.f.Close()
+}
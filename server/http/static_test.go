@@ -0,0 +1,116 @@
+package http_test
+
+import (
+	"io/ioutil"
+	nethttp "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/zhlls/go-common/server/http"
+	"github.com/zhlls/go-common/server/http/httptest"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newStaticServer(t *testing.T) *httptest.Server {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"secret.txt":        "outside the root",
+		"ui/index.html":     "<html>index</html>",
+		"ui/app.js":         "console.log(1)",
+		"ui/.env":           "SECRET=1",
+		"ui/.git/config":    "[core]",
+		"ui/files/a.txt":    "a",
+		"ui/files/.hidden":  "hidden",
+		"dotfiles/.profile": "profile",
+	})
+	srv := http.NewServer("")
+	srv.Static("/files", http.StaticOptions{Root: nethttp.Dir(filepath.Join(dir, "ui", "files")), Browse: true})
+	srv.Static("/dotfiles", http.StaticOptions{Root: nethttp.Dir(filepath.Join(dir, "dotfiles")), Dotfiles: true})
+	srv.Static("/", http.StaticOptions{Root: nethttp.Dir(filepath.Join(dir, "ui")), SPA: true})
+	ts := httptest.NewServer(t, srv)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestStatic(t *testing.T) {
+	ts := newStaticServer(t)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"index", "GET", "/", fasthttp.StatusOK, "<html>index</html>"},
+		{"file", "GET", "/app.js", fasthttp.StatusOK, "console.log(1)"},
+		{"head", "HEAD", "/app.js", fasthttp.StatusOK, ""},
+		{"spa route", "GET", "/users/42", fasthttp.StatusOK, "<html>index</html>"},
+		{"missing file", "GET", "/missing.js", fasthttp.StatusNotFound, ""},
+		{"api path", "GET", "/rest/unknown", fasthttp.StatusNotFound, ""},
+		{"dotfile", "GET", "/.env", fasthttp.StatusNotFound, ""},
+		{"dot directory", "GET", "/.git/config", fasthttp.StatusNotFound, ""},
+		{"dotfiles allowed", "GET", "/dotfiles/.profile", fasthttp.StatusOK, "profile"},
+		{"post file", "POST", "/app.js", fasthttp.StatusNotFound, ""},
+		{"post api path", "POST", "/rest/unknown", fasthttp.StatusNotFound, ""},
+		{"delete index", "DELETE", "/", fasthttp.StatusNotFound, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := ts.NewRequest(tc.method, tc.path).Expect().Status(tc.status).Raw()
+			if tc.body != "" && string(resp.Body()) != tc.body {
+				t.Errorf("body %q, want %q", resp.Body(), tc.body)
+			}
+		})
+	}
+}
+
+func TestStaticBrowseHidesDotfiles(t *testing.T) {
+	ts := newStaticServer(t)
+	body := string(ts.GET("/files/").Expect().Status(fasthttp.StatusOK).Raw().Body())
+	if !strings.Contains(body, "a.txt") || strings.Contains(body, ".hidden") {
+		t.Errorf("listing %s, want a.txt without .hidden", body)
+	}
+}
+
+func TestStaticRange(t *testing.T) {
+	ts := newStaticServer(t)
+	ts.GET("/app.js").
+		WithHeader("Range", "bytes=0-6").
+		Expect().
+		Status(fasthttp.StatusPartialContent).
+		Header("Content-Range", "bytes 0-6/14").
+		Body([]byte("console"))
+	ts.GET("/app.js").
+		WithHeader("Range", "bytes=100-200").
+		Expect().
+		Status(fasthttp.StatusRequestedRangeNotSatisfiable).
+		Header("Content-Range", "bytes */14")
+}
+
+func TestStaticPathTraversal(t *testing.T) {
+	ts := newStaticServer(t)
+	for _, p := range []string{"/../secret.txt", "/..%2fsecret.txt", "/files/../../secret.txt"} {
+		req := ts.GET(p)
+		req.Raw().URI().DisablePathNormalizing = true
+		resp := req.Expect().Raw()
+		if strings.Contains(string(resp.Body()), "outside the root") {
+			t.Errorf("%s served a file outside the root", p)
+		}
+	}
+}
@@ -2,17 +2,33 @@ package http
 
 import (
 	"context"
+	"net"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
 
 	"github.com/zhlls/go-common/log"
+	"github.com/zhlls/go-common/metrics"
+	"github.com/zhlls/go-common/tracing"
 )
 
 const spanCtxKey = "__span_context__"
 
+// RequestIDUserValue is the user value holding the X-Request-Id of a traced
+// request. The trace context carries it for log.Ctx, see log.WithRequestID.
+const RequestIDUserValue = "__request_id__"
+
+// SetTraceResponseHeaders controls whether the W3C traceparent of the server
+// span is written to the response, so callers can look up the trace. It is
+// off by default, baggage and vendor trace state are never written.
+func (s *Server) SetTraceResponseHeaders(enable bool) {
+	s.traceResponseHeaders = enable
+}
+
 type HeadersCarrierWriter fasthttp.ResponseHeader
 type HeadersCarrierReader fasthttp.RequestHeader
 
@@ -33,7 +49,16 @@ func (c *HeadersCarrierReader) ForeachKey(handler func(key, val string) error) e
 	return nil
 }
 
-func tracerMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+func (s *Server) tracerMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if s.disableTrace {
+		return h
+	}
+	responseHeaders := s.traceResponseHeaders
+	var sampling *sampler
+	if s.sampling != nil {
+		sampling = newSampler(*s.sampling)
+	}
+
 	return func(ctx *fasthttp.RequestCtx) {
 		if shouldIgnore(ctx) {
 			h(ctx)
@@ -52,22 +77,105 @@ func tracerMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 			)
 		}
 		// just http, opentracing.ChildOf is enough, no need ext.RPCServerOption
-		sp := opentracing.GlobalTracer().StartSpan(
-			"tracerMiddleware", opentracing.ChildOf(spanCtx))
-		ext.HTTPMethod.Set(sp, string(ctx.Method()))
-		ext.HTTPUrl.Set(sp, ctx.URI().String())
-		ext.Component.Set(sp, "fasthttp")
+		// the route is only known after routing, the name is fixed up below.
+		// the kind is a start option so the OpenTelemetry bridge picks it up
+		opts := []opentracing.StartSpanOption{opentracing.ChildOf(spanCtx), ext.SpanKindRPCServer}
+		var sampled bool
+		var reason string
+		if sampling != nil {
+			if sampling.opts.HonourUpstream && spanCtx != nil {
+				reason = samplingReasonUpstream
+			} else {
+				sampled, reason = sampling.head(string(ctx.Path()))
+				opts = append(opts, samplingPriority(sampled))
+			}
+		}
+		start := time.Now()
+		sp := opentracing.GlobalTracer().StartSpan(string(ctx.Method()), opts...)
+		requestID := RequestID(ctx)
+		tagRequest := func() {
+			ext.HTTPMethod.Set(sp, string(ctx.Method()))
+			ext.HTTPUrl.Set(sp, string(ctx.Path()))
+			ext.Component.Set(sp, "fasthttp")
+			setPeerTag(sp, ClientIP(ctx))
+			if requestID != "" {
+				sp.SetTag("request.id", requestID)
+			}
+		}
+		tagRequest()
+		var parent context.Context = ctx
+		if requestID != "" {
+			ctx.SetUserValue(RequestIDUserValue, requestID)
+			// reaches log.Ctx through the trace context
+			parent = log.WithRequestID(parent, requestID)
+		}
 
-		nextCtx := opentracing.ContextWithSpan(ctx, sp)
+		nextCtx := opentracing.ContextWithSpan(parent, sp)
 		SetTraceContext(ctx, nextCtx)
 
+		if responseHeaders {
+			if tp := tracing.Traceparent(nextCtx); tp != "" {
+				ctx.Response.Header.Set("traceparent", tp)
+			}
+		}
+
 		h(ctx)
 
-		ext.HTTPStatusCode.Set(sp, uint16(ctx.Response.StatusCode()))
+		statusCode := ctx.Response.StatusCode()
+		if sampling != nil {
+			if info, ok := tracing.SpanInfoFromContext(nextCtx); ok {
+				sampled = info.Sampled
+			}
+			if !sampled {
+				if tail, why := sampling.tail(statusCode, time.Since(start)); tail {
+					// tracers drop the tags of unsampled spans, so raise the
+					// priority before tagging the response and tag the request again
+					ext.SamplingPriority.Set(sp, 1)
+					sampled, reason = true, why
+					tagRequest()
+				}
+			}
+			metrics.CollectTraceSpan(samplingDecision(sampled), reason)
+		}
+
+		if route := RouterPath(ctx); route != "" {
+			sp.SetOperationName(string(ctx.Method()) + " " + route)
+			sp.SetTag("http.route", route)
+		}
+		ext.HTTPStatusCode.Set(sp, uint16(statusCode))
+		if size := responseSize(&ctx.Response); size >= 0 {
+			sp.SetTag("http.response_size", size)
+		}
+		if statusCode >= fasthttp.StatusInternalServerError {
+			ext.Error.Set(sp, true)
+			sp.LogFields(
+				otlog.String("event", "error"),
+				otlog.Int("http.status_code", statusCode),
+				otlog.String("message", fasthttp.StatusMessage(statusCode)),
+			)
+		}
 		sp.Finish()
 	}
 }
 
+func setPeerTag(sp opentracing.Span, ip net.IP) {
+	if ip == nil {
+		return
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ext.PeerHostIPv4.SetString(sp, v4.String())
+		return
+	}
+	ext.PeerHostIPv6.Set(sp, ip.String())
+}
+
+// StartSpan starts a span named operationName as child of the request span
+// and returns it together with a context carrying it, for passing on to
+// clients. The caller must Finish the span.
+func StartSpan(ctx *fasthttp.RequestCtx, operationName string, opts ...opentracing.StartSpanOption) (opentracing.Span, context.Context) {
+	return opentracing.StartSpanFromContext(GetTraceContext(ctx), operationName, opts...)
+}
+
 func SetTraceContext(ctx *fasthttp.RequestCtx, spanCtx context.Context) {
 	ctx.SetUserValue(spanCtxKey, spanCtx)
 }
@@ -0,0 +1,190 @@
+package http_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	jaegerCfg "github.com/uber/jaeger-client-go/config"
+	"github.com/valyala/fasthttp"
+
+	"github.com/zhlls/go-common/log"
+	"github.com/zhlls/go-common/server/http"
+	"github.com/zhlls/go-common/server/http/httptest"
+	"github.com/zhlls/go-common/tracing"
+)
+
+func init() {
+	http.AddRouter(fasthttp.MethodGet, "/stream/{size}", func(ctx *fasthttp.RequestCtx) {
+		size := -1
+		if ctx.UserValue("size") == "known" {
+			size = len(largeText)
+		}
+		ctx.SetBodyStream(bytes.NewReader(largeText), size)
+	})
+	http.AddRouter(fasthttp.MethodGet, "/fail", func(ctx *fasthttp.RequestCtx) {
+		http.InternalServerError(ctx, "boom")
+	})
+	// answers with the trace header a call to a downstream service carries
+	http.AddRouter(fasthttp.MethodGet, "/outgoing", func(ctx *fasthttp.RequestCtx) {
+		sp, _ := http.StartSpan(ctx, "downstream")
+		defer sp.Finish()
+		carrier := opentracing.TextMapCarrier{}
+		_ = opentracing.GlobalTracer().Inject(sp.Context(), opentracing.TextMap, carrier)
+		ctx.Response.Header.Set("X-Outgoing", carrier["uber-trace-id"])
+	})
+	http.AddRouter(fasthttp.MethodGet, "/logged", func(ctx *fasthttp.RequestCtx) {
+		log.Ctx(http.GetTraceContext(ctx)).Info("handled")
+		fmt.Fprint(ctx, ctx.UserValue(http.RequestIDUserValue))
+	})
+}
+
+func TestTracerResponseSizeOfStream(t *testing.T) {
+	ts := httptest.NewServer(t, http.NewServer(""), httptest.CaptureSpans())
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		path string
+		size interface{}
+	}{
+		{"/rest/stream/known", len(largeText)},
+		// a chunked stream is not read to find its size
+		{"/rest/stream/chunked", nil},
+	} {
+		ts.ResetSpans()
+		ts.GET(tc.path).Expect().Body(largeText)
+		spans := ts.FinishedSpans()
+		if len(spans) != 1 {
+			t.Fatalf("%s: got %d spans, want 1", tc.path, len(spans))
+		}
+		if got := spans[0].Tag("http.response_size"); got != tc.size {
+			t.Errorf("%s: http.response_size %v, want %v", tc.path, got, tc.size)
+		}
+	}
+}
+
+func TestTraceResponseHeaders(t *testing.T) {
+	for _, enable := range []bool{false, true} {
+		srv := http.NewServer("")
+		srv.SetTraceResponseHeaders(enable)
+		ts := httptest.NewServer(t, srv, httptest.CaptureSpans())
+
+		resp := ts.GET("/rest/stream/known").Expect().Raw()
+		sp := ts.FinishedSpans()[0]
+		want := ""
+		if enable {
+			flags := "00"
+			if sp.SpanContext.Sampled {
+				flags = "01"
+			}
+			want = fmt.Sprintf("00-%032x-%016x-%s", sp.SpanContext.TraceID, sp.SpanContext.SpanID, flags)
+		}
+		if got := string(resp.Header.Peek("traceparent")); got != want {
+			t.Errorf("enable %v: traceparent %q, want %q", enable, got, want)
+		}
+		resp.Header.VisitAll(func(k, _ []byte) {
+			// the mock tracer propagates as mockpfx-ids-* and baggage as mockpfx-baggage-*
+			if strings.HasPrefix(strings.ToLower(string(k)), "mockpfx-") {
+				t.Errorf("enable %v: leaked header %s", enable, k)
+			}
+		})
+		ts.Close()
+	}
+}
+
+func TestTailSampledSpanKeepsTags(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	prev := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(prev)
+
+	srv := http.NewServer("")
+	srv.SetSampling(http.SamplingOptions{Rate: 0, Errors: true})
+	ts := httptest.NewServer(t, srv)
+	defer ts.Close()
+
+	ts.GET("/rest/fail").Expect().Status(fasthttp.StatusInternalServerError)
+
+	spans := reporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d reported spans, want 1", len(spans))
+	}
+	sp := spans[0].(*jaeger.Span)
+	tags := sp.Tags()
+	for key, want := range map[string]interface{}{
+		"http.status_code": uint16(fasthttp.StatusInternalServerError),
+		"error":            true,
+		"http.method":      fasthttp.MethodGet,
+		"http.route":       "/rest/fail",
+	} {
+		if got := tags[key]; got != want {
+			t.Errorf("tag %s: got %v, want %v", key, got, want)
+		}
+	}
+	if len(sp.Logs()) == 0 {
+		t.Error("error event not logged")
+	}
+}
+
+func TestForcedSamplingWithoutDebugFlag(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	cfg := &jaegerCfg.Configuration{Sampler: &jaegerCfg.SamplerConfig{Type: jaeger.SamplerTypeConst, Param: 0}}
+	prev := opentracing.GlobalTracer()
+	closer, err := tracing.InitGlobalTracer("test", cfg, []tracing.Format{tracing.FormatJaeger},
+		jaegerCfg.Reporter(reporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	defer opentracing.SetGlobalTracer(prev)
+
+	srv := http.NewServer("")
+	srv.SetSampling(http.SamplingOptions{Rate: 1})
+	ts := httptest.NewServer(t, srv)
+	resp := ts.GET("/rest/outgoing").Expect().Raw()
+	ts.Close()
+	// uber-trace-id is trace:span:parent:flags, 1 is sampled, 2 debug
+	header := string(resp.Header.Peek("X-Outgoing"))
+	if parts := strings.Split(header, ":"); len(parts) != 4 || parts[3] != "1" {
+		t.Errorf("outgoing uber-trace-id %q of a head sampled request, want flags 1", header)
+	}
+
+	srv = http.NewServer("")
+	srv.SetSampling(http.SamplingOptions{Rate: 0, Errors: true})
+	ts = httptest.NewServer(t, srv)
+	ts.GET("/rest/fail").Expect().Status(fasthttp.StatusInternalServerError)
+	ts.Close()
+	spans := reporter.GetSpans()
+	sc := spans[len(spans)-1].Context().(jaeger.SpanContext)
+	if !sc.IsSampled() || sc.IsDebug() {
+		t.Errorf("tail sampled span: sampled %v debug %v, want sampled without debug", sc.IsSampled(), sc.IsDebug())
+	}
+}
+
+func TestTraceContextLogFields(t *testing.T) {
+	ts := httptest.NewServer(t, http.NewServer(""), httptest.CaptureSpans(), httptest.CaptureLogs(log.InfoLevel))
+	defer ts.Close()
+
+	ts.GET("/rest/logged").WithHeader("X-Request-Id", "req-1").Expect().Status(fasthttp.StatusOK).Body([]byte("req-1"))
+	sc := ts.FinishedSpans()[0].SpanContext
+	entries := ts.Logs().FilterMessage("handled").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	want := map[string]interface{}{
+		"request_id": "req-1",
+		"trace_id":   fmt.Sprintf("%016x", sc.TraceID),
+		"span_id":    fmt.Sprintf("%016x", sc.SpanID),
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("%s %v, want %v", k, fields[k], v)
+		}
+	}
+}
@@ -18,6 +18,8 @@ var (
 	//strContentType = []byte("Content-Type")
 	StrApplicationJSON = []byte("application/json")
 
+	StrRequestID = []byte("X-Request-Id")
+
 	errPathArgRequired = errors.New("path arg required")
 	errPathArgInvalid  = errors.New("path arg invalid")
 )
@@ -40,6 +42,20 @@ func GetPathArg(ctx *fasthttp.RequestCtx, name string) (string, error) {
 	return value, nil
 }
 
+// RequestID returns the X-Request-Id header of the request, "" when unset.
+func RequestID(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Request.Header.PeekBytes(StrRequestID))
+}
+
+// responseSize returns the size of the response body without reading
+// streamed bodies, for which it is the Content-Length, negative when unknown.
+func responseSize(resp *fasthttp.Response) int {
+	if resp.IsBodyStream() {
+		return resp.Header.ContentLength()
+	}
+	return len(resp.Body())
+}
+
 type errorMsg struct {
 	Msg string `json:"msg"`
 }
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/zhlls/go-common/log"
+)
+
+func init() {
+	log.RegisterContextFields(LogFields)
+}
+
+// SpanInfo identifies the active span of a context.
+type SpanInfo struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// IDSpanContext is implemented by span contexts of other tracers, e.g. a
+// mock tracer in tests, to make their ids known to SpanInfoFromContext and
+// Traceparent.
+type IDSpanContext interface {
+	// TraceID returns the high and low 64 bits of the trace id, high is
+	// zero for 64 bit ids.
+	TraceID() (high, low uint64)
+	SpanID() uint64
+	IsSampled() bool
+}
+
+// SpanInfoFromContext returns the ids of the span in ctx, for the jaeger and
+// OpenTelemetry tracers and span contexts implementing IDSpanContext. ok is
+// false when ctx carries no span or the tracer is unknown.
+func SpanInfoFromContext(ctx context.Context) (info SpanInfo, ok bool) {
+	if ctx == nil {
+		return info, false
+	}
+	if sp := opentracing.SpanFromContext(ctx); sp != nil {
+		switch sc := sp.Context().(type) {
+		case jaeger.SpanContext:
+			return SpanInfo{
+				TraceID: sc.TraceID().String(),
+				SpanID:  sc.SpanID().String(),
+				Sampled: sc.IsSampled(),
+			}, true
+		case IDSpanContext:
+			high, low := sc.TraceID()
+			return SpanInfo{
+				TraceID: jaeger.TraceID{High: high, Low: low}.String(),
+				SpanID:  jaeger.SpanID(sc.SpanID()).String(),
+				Sampled: sc.IsSampled(),
+			}, true
+		}
+	}
+	// the OpenTelemetry bridge keeps its own span next to the opentracing one
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return SpanInfo{
+			TraceID: sc.TraceID().String(),
+			SpanID:  sc.SpanID().String(),
+			Sampled: sc.IsSampled(),
+		}, true
+	}
+	return info, false
+}
+
+// Traceparent returns the W3C traceparent of the span in ctx, "" when ctx
+// carries no span or the tracer is unknown, see SpanInfoFromContext.
+func Traceparent(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if sp := opentracing.SpanFromContext(ctx); sp != nil {
+		switch sc := sp.Context().(type) {
+		case jaeger.SpanContext:
+			traceID := sc.TraceID()
+			return formatTraceparent(traceID.High, traceID.Low, uint64(sc.SpanID()), sc.IsSampled())
+		case IDSpanContext:
+			high, low := sc.TraceID()
+			return formatTraceparent(high, low, sc.SpanID(), sc.IsSampled())
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		flags := "00"
+		if sc.IsSampled() {
+			flags = "01"
+		}
+		return "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + flags
+	}
+	return ""
+}
+
+// LogFields returns the trace_id and span_id fields of the span in ctx, as
+// added to entries logged with log.Ctx.
+func LogFields(ctx context.Context) []zapcore.Field {
+	info, ok := SpanInfoFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return []zapcore.Field{
+		zap.String("trace_id", info.TraceID),
+		zap.String("span_id", info.SpanID),
+	}
+}
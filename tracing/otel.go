@@ -0,0 +1,288 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	otjaeger "go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/zhlls/go-common/log"
+)
+
+const (
+	// ExporterStdout writes spans as OTLP JSON lines to stdout.
+	ExporterStdout = "stdout"
+	// ExporterFile writes spans as OTLP JSON lines to OTelConfig.FilePath.
+	ExporterFile = "file"
+	// ExporterNone drops all spans.
+	ExporterNone = "none"
+
+	shutdownTimeout = 5 * time.Second
+)
+
+// OTelConfig configures an OpenTelemetry backed tracer.
+type OTelConfig struct {
+	ServiceName string
+
+	// Exporter is one of ExporterStdout, ExporterFile or ExporterNone.
+	Exporter string
+	// FilePath is the file spans are appended to with ExporterFile.
+	FilePath string
+
+	// SpanExporter overrides Exporter, e.g. with an in-memory exporter.
+	SpanExporter sdktrace.SpanExporter
+	// SyncExport exports every span as soon as it ends instead of in
+	// batches, which is what tests usually want.
+	SyncExport bool
+
+	// Formats are the header formats of the trace context, DefaultFormats
+	// when empty.
+	Formats []Format
+
+	// Sampler decides which traces are recorded, parent based always on
+	// when nil. A sampling.priority tag given at span start, as set by the
+	// sampling policy of server/http, overrides it.
+	Sampler sdktrace.Sampler
+}
+
+// OTelTracer is an opentracing.Tracer backed by the OpenTelemetry SDK, so
+// server/http, client/http and callers of GetTraceContext/SetTraceContext
+// keep working unchanged while new code uses the OpenTelemetry API.
+type OTelTracer struct {
+	*otbridge.BridgeTracer
+
+	provider   *sdktrace.TracerProvider
+	wrapper    trace.TracerProvider
+	propagator propagation.TextMapPropagator
+	closer     io.Closer
+}
+
+// NewOTelTracer creates an OpenTelemetry tracer as configured by cfg.
+func NewOTelTracer(cfg OTelConfig) (*OTelTracer, error) {
+	propagator, err := NewOTelPropagator(cfg.Formats...)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := cfg.SpanExporter
+	var closer io.Closer
+	if exporter == nil {
+		switch cfg.Exporter {
+		case ExporterStdout, "":
+			exporter = NewOTLPFileExporter(os.Stdout)
+		case ExporterFile:
+			if cfg.FilePath == "" {
+				return nil, errors.New("otel file exporter requires a file path")
+			}
+			f, err := os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return nil, err
+			}
+			exporter, closer = NewOTLPFileExporter(f), f
+		case ExporterNone:
+			exporter = tracetest.NewNoopExporter()
+		default:
+			return nil, fmt.Errorf("unknown otel exporter %q", cfg.Exporter)
+		}
+	}
+
+	sampler := cfg.Sampler
+	if sampler == nil {
+		sampler = sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+	var processor sdktrace.SpanProcessor
+	if cfg.SyncExport {
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	} else {
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(tailProcessor{processor}),
+		sdktrace.WithSampler(PrioritySampler(sampler)),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL, semconv.ServiceNameKey.String(cfg.ServiceName))),
+	)
+
+	bridge, wrapper := otbridge.NewTracerPair(provider.Tracer("github.com/zhlls/go-common/tracing"))
+	bridge.SetTextMapPropagator(propagator)
+	bridge.SetWarningHandler(func(msg string) {
+		log.Debug("otel bridge: " + msg)
+	})
+
+	return &OTelTracer{
+		BridgeTracer: bridge,
+		provider:     provider,
+		wrapper:      wrapper,
+		propagator:   propagator,
+		closer:       closer,
+	}, nil
+}
+
+// InitGlobalOTelTracer creates an OpenTelemetry tracer and installs it as
+// the opentracing global tracer as well as the OpenTelemetry global tracer
+// provider and propagator. OpenTelemetry errors are logged from then on,
+// the global error handler can only be installed once.
+func InitGlobalOTelTracer(cfg OTelConfig) (*OTelTracer, error) {
+	t, err := NewOTelTracer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opentracing.SetGlobalTracer(t)
+	otel.SetTracerProvider(t.wrapper)
+	otel.SetTextMapPropagator(t.propagator)
+	otel.SetErrorHandler(logErrorHandler{})
+	return t, nil
+}
+
+// NewInMemoryOTelTracer returns a tracer exporting every finished span to
+// the returned exporter, for tests.
+func NewInMemoryOTelTracer(serviceName string, formats ...Format) (*OTelTracer, *tracetest.InMemoryExporter, error) {
+	exporter := tracetest.NewInMemoryExporter()
+	t, err := NewOTelTracer(OTelConfig{
+		ServiceName:  serviceName,
+		SpanExporter: exporter,
+		SyncExport:   true,
+		Formats:      formats,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, exporter, nil
+}
+
+// TracerProvider returns the OpenTelemetry provider whose spans interleave
+// with the opentracing spans of this tracer.
+func (t *OTelTracer) TracerProvider() trace.TracerProvider {
+	return t.wrapper
+}
+
+// Close flushes pending spans and releases the exporter.
+func (t *OTelTracer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := t.provider.Shutdown(ctx)
+	if t.closer != nil {
+		if cerr := t.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// StartSpan starts a span of the bridge. The span.kind tag set with the
+// ext.SpanKind helpers is passed as plain string, which is the only type the
+// bridge maps to the OpenTelemetry span kind.
+func (t *OTelTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	return t.BridgeTracer.StartSpan(operationName, append(opts, spanKindOption{})...)
+}
+
+type spanKindOption struct{}
+
+func (spanKindOption) Apply(o *opentracing.StartSpanOptions) {
+	if kind, ok := o.Tags[string(ext.SpanKind)].(ext.SpanKindEnum); ok {
+		o.Tags[string(ext.SpanKind)] = string(kind)
+	}
+}
+
+// Inject accepts any opentracing.TextMapWriter carrier for the HTTPHeaders
+// and TextMap formats, e.g. server/http.HeadersCarrierWriter, while the
+// bridge itself only supports opentracing.HTTPHeadersCarrier.
+func (t *OTelTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	if format != opentracing.HTTPHeaders && format != opentracing.TextMap {
+		return opentracing.ErrUnsupportedFormat
+	}
+	if hc, ok := carrier.(opentracing.HTTPHeadersCarrier); ok {
+		return t.BridgeTracer.Inject(sc, opentracing.HTTPHeaders, hc)
+	}
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+
+	header := http.Header{}
+	if err := t.BridgeTracer.Inject(sc, opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		return err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			writer.Set(k, v)
+		}
+	}
+	return nil
+}
+
+// Extract accepts any opentracing.TextMapReader carrier, see Inject.
+func (t *OTelTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	if format != opentracing.HTTPHeaders && format != opentracing.TextMap {
+		return nil, opentracing.ErrUnsupportedFormat
+	}
+	if hc, ok := carrier.(opentracing.HTTPHeadersCarrier); ok {
+		return t.BridgeTracer.Extract(opentracing.HTTPHeaders, hc)
+	}
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+
+	header := http.Header{}
+	err := reader.ForeachKey(func(key, val string) error {
+		header.Add(key, val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.BridgeTracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+}
+
+// NewOTelPropagator returns the OpenTelemetry propagator for formats, plus
+// W3C baggage. Like NewPropagator, every format is injected and the first
+// format found wins on extraction.
+func NewOTelPropagator(formats ...Format) (propagation.TextMapPropagator, error) {
+	if len(formats) == 0 {
+		formats = DefaultFormats
+	}
+	// the composite propagator lets later extractors override earlier ones,
+	// so the list is built in reverse
+	propagators := []propagation.TextMapPropagator{propagation.Baggage{}}
+	for i := len(formats) - 1; i >= 0; i-- {
+		switch formats[i] {
+		case FormatJaeger:
+			propagators = append(propagators, otjaeger.Jaeger{})
+		case FormatW3C:
+			propagators = append(propagators, propagation.TraceContext{})
+		case FormatB3:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case FormatB3Single:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		default:
+			return nil, fmt.Errorf("unknown trace propagation format %q", formats[i])
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+// logErrorHandler logs the errors of the OpenTelemetry SDK, e.g. failed
+// exports.
+type logErrorHandler struct{}
+
+func (logErrorHandler) Handle(err error) {
+	log.Warn("otel error", zap.Error(err))
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestOTelTracer(t *testing.T, formats ...Format) (*OTelTracer, *tracetest.InMemoryExporter) {
+	tracer, exporter, err := NewInMemoryOTelTracer("test", formats...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tracer.Close() })
+	return tracer, exporter
+}
+
+func spanByName(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, s := range exporter.GetSpans() {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no span %q exported", name)
+	return tracetest.SpanStub{}
+}
+
+func TestOTelBridgeSpans(t *testing.T) {
+	tracer, exporter := newTestOTelTracer(t)
+
+	parent := tracer.StartSpan("parent", ext.SpanKindRPCServer)
+	child := tracer.StartSpan("child", opentracing.ChildOf(parent.Context()), ext.SpanKindRPCClient)
+	child.SetTag("http.status_code", 200)
+	child.SetTag("user", "alice")
+	child.Finish()
+	parent.Finish()
+
+	p := spanByName(t, exporter, "parent")
+	c := spanByName(t, exporter, "child")
+	if p.SpanKind != trace.SpanKindServer || c.SpanKind != trace.SpanKindClient {
+		t.Errorf("span kinds %v and %v, want server and client", p.SpanKind, c.SpanKind)
+	}
+	if c.SpanContext.TraceID() != p.SpanContext.TraceID() || c.Parent.SpanID() != p.SpanContext.SpanID() {
+		t.Errorf("child %v of %v, want it in the trace of its parent", c.Parent, p.SpanContext)
+	}
+	attrs := map[string]string{}
+	for _, kv := range c.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["http.status_code"] != "200" || attrs["user"] != "alice" {
+		t.Errorf("attributes %v, want the tags of the span", attrs)
+	}
+}
+
+func TestOTelBridgePropagation(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		header string
+	}{
+		{FormatW3C, "Traceparent"},
+		{FormatB3, "X-B3-Traceid"},
+		{FormatB3Single, "B3"},
+		{FormatJaeger, "Uber-Trace-Id"},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			tracer, exporter := newTestOTelTracer(t, tc.format)
+			parent := tracer.StartSpan("parent")
+			header := http.Header{}
+			if err := tracer.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+				t.Fatal(err)
+			}
+			if header.Get(tc.header) == "" {
+				t.Fatalf("headers %v, want %s", header, tc.header)
+			}
+
+			// a plain TextMap carrier goes through the same propagator
+			carrier := opentracing.TextMapCarrier{}
+			for k := range header {
+				carrier.Set(k, header.Get(k))
+			}
+			sc, err := tracer.Extract(opentracing.TextMap, carrier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracer.StartSpan("child", opentracing.ChildOf(sc)).Finish()
+			parent.Finish()
+
+			p := spanByName(t, exporter, "parent")
+			c := spanByName(t, exporter, "child")
+			if c.SpanContext.TraceID() != p.SpanContext.TraceID() || c.Parent.SpanID() != p.SpanContext.SpanID() {
+				t.Errorf("extracted child %v, want the parent %v", c.Parent, p.SpanContext)
+			}
+		})
+	}
+}
+
+func TestOTelErrorHandlerInstalledByInit(t *testing.T) {
+	if _, ok := otel.GetErrorHandler().(logErrorHandler); ok {
+		t.Fatal("error handler installed before InitGlobalOTelTracer")
+	}
+	prev := opentracing.GlobalTracer()
+	defer opentracing.SetGlobalTracer(prev)
+	tracer, err := InitGlobalOTelTracer(OTelConfig{ServiceName: "test", Exporter: ExporterNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close()
+	if _, ok := otel.GetErrorHandler().(logErrorHandler); !ok {
+		t.Errorf("error handler %T, want the log handler", otel.GetErrorHandler())
+	}
+}
@@ -0,0 +1,250 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTLPFileExporter writes spans to an io.Writer in the OTLP/JSON encoding,
+// one ExportTraceServiceRequest per line, so collectors and tools which read
+// the OpenTelemetry file format can pick them up.
+type OTLPFileExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewOTLPFileExporter returns an exporter writing to w.
+func NewOTLPFileExporter(w io.Writer) *OTLPFileExporter {
+	return &OTLPFileExporter{enc: json.NewEncoder(w)}
+}
+
+// ExportSpans implements sdktrace.SpanExporter.
+func (e *OTLPFileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	req := otlpRequest{}
+	resources := map[interface{}]int{}
+	for _, s := range spans {
+		key := s.Resource().Equivalent()
+		ri, ok := resources[key]
+		if !ok {
+			ri = len(req.ResourceSpans)
+			resources[key] = ri
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource:  otlpResource{Attributes: otlpAttributes(s.Resource().Attributes())},
+				SchemaURL: s.Resource().SchemaURL(),
+			})
+		}
+		rs := &req.ResourceSpans[ri]
+
+		lib := s.InstrumentationLibrary()
+		li := -1
+		for i, ils := range rs.InstrumentationLibrarySpans {
+			if ils.InstrumentationLibrary.Name == lib.Name && ils.InstrumentationLibrary.Version == lib.Version {
+				li = i
+				break
+			}
+		}
+		if li < 0 {
+			li = len(rs.InstrumentationLibrarySpans)
+			rs.InstrumentationLibrarySpans = append(rs.InstrumentationLibrarySpans, otlpLibrarySpans{
+				InstrumentationLibrary: otlpLibrary{Name: lib.Name, Version: lib.Version},
+				SchemaURL:              lib.SchemaURL,
+			})
+		}
+		ils := &rs.InstrumentationLibrarySpans[li]
+		ils.Spans = append(ils.Spans, otlpSpanFrom(s))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(req)
+}
+
+// Shutdown implements sdktrace.SpanExporter.
+func (e *OTLPFileExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource                    otlpResource       `json:"resource"`
+	InstrumentationLibrarySpans []otlpLibrarySpans `json:"instrumentationLibrarySpans"`
+	SchemaURL                   string             `json:"schemaUrl,omitempty"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLibrarySpans struct {
+	InstrumentationLibrary otlpLibrary `json:"instrumentationLibrary"`
+	Spans                  []otlpSpan  `json:"spans"`
+	SchemaURL              string      `json:"schemaUrl,omitempty"`
+}
+
+type otlpLibrary struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID                string         `json:"traceId"`
+	SpanID                 string         `json:"spanId"`
+	TraceState             string         `json:"traceState,omitempty"`
+	ParentSpanID           string         `json:"parentSpanId,omitempty"`
+	Name                   string         `json:"name"`
+	Kind                   int            `json:"kind"`
+	StartTimeUnixNano      string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano        string         `json:"endTimeUnixNano"`
+	Attributes             []otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int            `json:"droppedAttributesCount,omitempty"`
+	Events                 []otlpEvent    `json:"events,omitempty"`
+	DroppedEventsCount     int            `json:"droppedEventsCount,omitempty"`
+	Links                  []otlpLink     `json:"links,omitempty"`
+	DroppedLinksCount      int            `json:"droppedLinksCount,omitempty"`
+	Status                 otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	TraceState string         `json:"traceState,omitempty"`
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpValue `json:"values"`
+}
+
+func otlpSpanFrom(s sdktrace.ReadOnlySpan) otlpSpan {
+	sc := s.SpanContext()
+	span := otlpSpan{
+		TraceID:                sc.TraceID().String(),
+		SpanID:                 sc.SpanID().String(),
+		TraceState:             sc.TraceState().String(),
+		Name:                   s.Name(),
+		Kind:                   int(s.SpanKind()),
+		StartTimeUnixNano:      strconv.FormatInt(s.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:        strconv.FormatInt(s.EndTime().UnixNano(), 10),
+		Attributes:             otlpAttributes(s.Attributes()),
+		DroppedAttributesCount: s.DroppedAttributes(),
+		DroppedEventsCount:     s.DroppedEvents(),
+		DroppedLinksCount:      s.DroppedLinks(),
+	}
+	if s.Parent().HasSpanID() {
+		span.ParentSpanID = s.Parent().SpanID().String()
+	}
+	for _, ev := range s.Events() {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+			Name:         ev.Name,
+			Attributes:   otlpAttributes(ev.Attributes),
+		})
+	}
+	for _, l := range s.Links() {
+		span.Links = append(span.Links, otlpLink{
+			TraceID:    l.SpanContext.TraceID().String(),
+			SpanID:     l.SpanContext.SpanID().String(),
+			TraceState: l.SpanContext.TraceState().String(),
+			Attributes: otlpAttributes(l.Attributes),
+		})
+	}
+
+	// OTLP orders the codes unset, ok, error
+	switch s.Status().Code {
+	case codes.Ok:
+		span.Status.Code = 1
+	case codes.Error:
+		span.Status.Code = 2
+		span.Status.Message = s.Status().Description
+	}
+	return span
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: string(kv.Key), Value: otlpValueOf(kv.Value)})
+	}
+	return kvs
+}
+
+func otlpValueOf(v attribute.Value) otlpValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		b := v.AsBool()
+		return otlpValue{BoolValue: &b}
+	case attribute.INT64:
+		i := strconv.FormatInt(v.AsInt64(), 10)
+		return otlpValue{IntValue: &i}
+	case attribute.FLOAT64:
+		f := v.AsFloat64()
+		return otlpValue{DoubleValue: &f}
+	case attribute.BOOLSLICE:
+		var values []otlpValue
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, otlpValueOf(attribute.BoolValue(b)))
+		}
+		return otlpValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case attribute.INT64SLICE:
+		var values []otlpValue
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, otlpValueOf(attribute.Int64Value(i)))
+		}
+		return otlpValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case attribute.FLOAT64SLICE:
+		var values []otlpValue
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, otlpValueOf(attribute.Float64Value(f)))
+		}
+		return otlpValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case attribute.STRINGSLICE:
+		var values []otlpValue
+		for _, s := range v.AsStringSlice() {
+			values = append(values, otlpValueOf(attribute.StringValue(s)))
+		}
+		return otlpValue{ArrayValue: &otlpArrayValue{Values: values}}
+	default:
+		s := v.Emit()
+		return otlpValue{StringValue: &s}
+	}
+}
@@ -0,0 +1,286 @@
+// Package tracing configures distributed tracing shared by server/http and
+// client/http: which header formats carry the trace context across
+// services, and how the global tracer is set up.
+package tracing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	jaegerCfg "github.com/uber/jaeger-client-go/config"
+	"github.com/uber/jaeger-client-go/zipkin"
+)
+
+// Format is a header format used to propagate the trace context.
+type Format string
+
+const (
+	// FormatJaeger is the native uber-trace-id header.
+	FormatJaeger Format = "jaeger"
+	// FormatW3C is the W3C Trace Context traceparent/tracestate pair.
+	FormatW3C Format = "w3c"
+	// FormatB3 is the Zipkin B3 multi header format (X-B3-TraceId, ...).
+	FormatB3 Format = "b3"
+	// FormatB3Single is the Zipkin B3 single header format (b3).
+	FormatB3Single Format = "b3single"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+	b3SingleHeader    = "b3"
+
+	// TraceStateBaggageKey carries an incoming W3C tracestate through the
+	// span context, so it is passed on unchanged to downstream services in
+	// the tracestate header, never as baggage of the other formats.
+	TraceStateBaggageKey = "w3c-tracestate"
+)
+
+// DefaultFormats are used when no format is configured.
+var DefaultFormats = []Format{FormatW3C, FormatB3, FormatJaeger}
+
+// Propagator injects and extracts jaeger span contexts.
+type Propagator interface {
+	jaeger.Injector
+	jaeger.Extractor
+}
+
+// ParseFormats parses a comma separated list of formats, e.g. "w3c,b3".
+func ParseFormats(s string) ([]Format, error) {
+	var formats []Format
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		switch Format(f) {
+		case FormatJaeger, FormatW3C, FormatB3, FormatB3Single:
+			formats = append(formats, Format(f))
+		default:
+			return nil, fmt.Errorf("unknown trace propagation format %q", f)
+		}
+	}
+	if len(formats) == 0 {
+		return DefaultFormats, nil
+	}
+	return formats, nil
+}
+
+// NewPropagator returns a propagator which injects every format and
+// extracts from the first format found in the carrier, in the given order.
+func NewPropagator(formats ...Format) (Propagator, error) {
+	if len(formats) == 0 {
+		formats = DefaultFormats
+	}
+	p := &compositePropagator{}
+	for _, f := range formats {
+		switch f {
+		case FormatJaeger:
+			p.propagators = append(p.propagators,
+				jaeger.NewHTTPHeaderPropagator(new(jaeger.HeadersConfig).ApplyDefaults(), *jaeger.NewNullMetrics()))
+		case FormatW3C:
+			p.propagators = append(p.propagators, w3cPropagator{})
+		case FormatB3:
+			p.propagators = append(p.propagators, zipkin.NewZipkinB3HTTPHeaderPropagator())
+		case FormatB3Single:
+			p.propagators = append(p.propagators, b3SinglePropagator{})
+		default:
+			return nil, fmt.Errorf("unknown trace propagation format %q", f)
+		}
+	}
+	return p, nil
+}
+
+// JaegerOptions returns the jaeger config options which install the
+// propagator of formats for the HTTPHeaders and TextMap carriers.
+func JaegerOptions(formats ...Format) ([]jaegerCfg.Option, error) {
+	p, err := NewPropagator(formats...)
+	if err != nil {
+		return nil, err
+	}
+	return []jaegerCfg.Option{
+		jaegerCfg.Injector(opentracing.HTTPHeaders, p),
+		jaegerCfg.Extractor(opentracing.HTTPHeaders, p),
+		jaegerCfg.Injector(opentracing.TextMap, p),
+		jaegerCfg.Extractor(opentracing.TextMap, p),
+	}, nil
+}
+
+type compositePropagator struct {
+	propagators []Propagator
+}
+
+func (p *compositePropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	// the tracestate belongs to the traceparent, other formats would send
+	// it on as baggage, e.g. uberctx-w3c-tracestate
+	withoutState := sc.WithBaggageItem(TraceStateBaggageKey, "")
+	for _, prop := range p.propagators {
+		psc := withoutState
+		if _, ok := prop.(w3cPropagator); ok {
+			psc = sc
+		}
+		if err := prop.Inject(psc, carrier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *compositePropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	err := opentracing.ErrSpanContextNotFound
+	for _, prop := range p.propagators {
+		sc, e := prop.Extract(carrier)
+		if e == nil {
+			return sc, nil
+		}
+		if e != opentracing.ErrSpanContextNotFound {
+			err = e
+		}
+	}
+	return jaeger.SpanContext{}, err
+}
+
+// readHeaders collects the lower cased values of keys from carrier.
+func readHeaders(carrier interface{}, keys ...string) (map[string]string, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+	values := make(map[string]string, len(keys))
+	err := reader.ForeachKey(func(key, val string) error {
+		key = strings.ToLower(key)
+		for _, k := range keys {
+			if k == key {
+				values[k] = val
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+type w3cPropagator struct{}
+
+func (w3cPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	traceID := sc.TraceID()
+	writer.Set(traceparentHeader, formatTraceparent(traceID.High, traceID.Low, uint64(sc.SpanID()), sc.IsSampled()))
+
+	var state string
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		if k == TraceStateBaggageKey {
+			state = v
+			return false
+		}
+		return true
+	})
+	if state != "" {
+		writer.Set(tracestateHeader, state)
+	}
+	return nil
+}
+
+func formatTraceparent(traceHigh, traceLow, spanID uint64, sampled bool) string {
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%016x%016x-%016x-%s", traceHigh, traceLow, spanID, flags)
+}
+
+func (w3cPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	values, err := readHeaders(carrier, traceparentHeader, tracestateHeader)
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	tp, ok := values[traceparentHeader]
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	parts := strings.Split(strings.TrimSpace(tp), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	traceID, err := jaeger.TraceIDFromString(parts[1])
+	if err != nil || !traceID.IsValid() {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := jaeger.SpanIDFromString(parts[2])
+	if err != nil || spanID == 0 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	var baggage map[string]string
+	if state := values[tracestateHeader]; state != "" {
+		baggage = map[string]string{TraceStateBaggageKey: state}
+	}
+	return jaeger.NewSpanContext(traceID, spanID, 0, flags&1 == 1, baggage), nil
+}
+
+type b3SinglePropagator struct{}
+
+func (b3SinglePropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	v := sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + sampled
+	if sc.ParentID() != 0 {
+		v += "-" + sc.ParentID().String()
+	}
+	writer.Set(b3SingleHeader, v)
+	return nil
+}
+
+func (b3SinglePropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	values, err := readHeaders(carrier, b3SingleHeader)
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	v, ok := values[b3SingleHeader]
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	// a lone sampling decision carries no context to continue
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 2 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+	traceID, err := jaeger.TraceIDFromString(parts[0])
+	if err != nil || !traceID.IsValid() {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := jaeger.SpanIDFromString(parts[1])
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	var sampled bool
+	var parentID jaeger.SpanID
+	if len(parts) > 2 {
+		sampled = parts[2] == "1" || parts[2] == "d"
+	}
+	if len(parts) > 3 {
+		if parentID, err = jaeger.SpanIDFromString(parts[3]); err != nil {
+			return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+		}
+	}
+	return jaeger.NewSpanContext(traceID, spanID, parentID, sampled, nil), nil
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber/jaeger-client-go"
+)
+
+func newPropagator(t *testing.T, formats ...Format) Propagator {
+	p, err := NewPropagator(formats...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPropagatorRoundTrip(t *testing.T) {
+	traceID := jaeger.TraceID{High: 0x0123456789abcdef, Low: 0xfedcba9876543210}
+	for _, f := range []Format{FormatW3C, FormatB3, FormatB3Single, FormatJaeger} {
+		for _, sampled := range []bool{false, true} {
+			p := newPropagator(t, f)
+			sc := jaeger.NewSpanContext(traceID, 0x1122334455667788, 0, sampled, nil)
+			carrier := opentracing.TextMapCarrier{}
+			if err := p.Inject(sc, carrier); err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Extract(carrier)
+			if err != nil {
+				t.Fatalf("%s: %v", f, err)
+			}
+			if got.TraceID() != traceID || got.SpanID() != sc.SpanID() || got.IsSampled() != sampled {
+				t.Errorf("%s: extracted %v sampled %v from %v, want %v sampled %v",
+					f, got, got.IsSampled(), carrier, sc, sampled)
+			}
+		}
+	}
+}
+
+func TestPropagatorExtractsFirstFormat(t *testing.T) {
+	carrier := opentracing.TextMapCarrier{
+		"traceparent": "00-0123456789abcdef0123456789abcdef-0123456789abcdef-01",
+		"b3":          "fedcba9876543210-fedcba9876543210-1",
+	}
+	sc, err := newPropagator(t, FormatW3C, FormatB3Single).Extract(carrier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.SpanID().String(); got != "0123456789abcdef" {
+		t.Errorf("span id %s, want the traceparent one", got)
+	}
+
+	carrier["traceparent"] = "00-0123-corrupted-01"
+	if _, err := newPropagator(t, FormatW3C).Extract(carrier); err != opentracing.ErrSpanContextCorrupted {
+		t.Errorf("corrupted traceparent: %v, want ErrSpanContextCorrupted", err)
+	}
+}
+
+func TestPropagatorKeepsTraceStateOutOfBaggage(t *testing.T) {
+	in := opentracing.TextMapCarrier{
+		"traceparent": "00-0123456789abcdef0123456789abcdef-0123456789abcdef-01",
+		"tracestate":  "vendor=opaque",
+	}
+	p := newPropagator(t, FormatW3C, FormatB3, FormatJaeger)
+	sc, err := p.Extract(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc = sc.WithBaggageItem("user", "alice")
+
+	out := opentracing.TextMapCarrier{}
+	if err := p.Inject(sc, out); err != nil {
+		t.Fatal(err)
+	}
+	if out["tracestate"] != "vendor=opaque" {
+		t.Errorf("tracestate %q, want it passed on", out["tracestate"])
+	}
+	for k := range out {
+		if k != "tracestate" && strings.Contains(k, "tracestate") {
+			t.Errorf("tracestate leaked as %s", k)
+		}
+	}
+	if out["uberctx-user"] != "alice" || out["baggage-user"] != "alice" {
+		t.Errorf("headers %v, want the other baggage kept", out)
+	}
+}
+
+// idContext is a span context of another tracer.
+type idContext struct {
+	mocktracer.MockSpanContext
+}
+
+func (c idContext) TraceID() (high, low uint64) { return 1, uint64(c.MockSpanContext.TraceID) }
+func (c idContext) SpanID() uint64              { return uint64(c.MockSpanContext.SpanID) }
+func (c idContext) IsSampled() bool             { return c.Sampled }
+
+type idSpan struct {
+	opentracing.Span
+}
+
+func (s idSpan) Context() opentracing.SpanContext {
+	return idContext{s.Span.Context().(mocktracer.MockSpanContext)}
+}
+
+func TestSpanInfoOfIDSpanContext(t *testing.T) {
+	sp := idSpan{mocktracer.New().StartSpan("test")}
+	sc := sp.Context().(idContext)
+	ctx := opentracing.ContextWithSpan(context.Background(), sp)
+
+	info, ok := SpanInfoFromContext(ctx)
+	wantTrace := jaeger.TraceID{High: 1, Low: uint64(sc.MockSpanContext.TraceID)}.String()
+	if !ok || info.TraceID != wantTrace || info.SpanID != jaeger.SpanID(sc.SpanID()).String() || info.Sampled != sc.Sampled {
+		t.Errorf("span info %+v, %v of %+v", info, ok, sc)
+	}
+	want := formatTraceparent(1, uint64(sc.MockSpanContext.TraceID), sc.SpanID(), sc.Sampled)
+	if got := Traceparent(ctx); got != want {
+		t.Errorf("traceparent %q, want %q", got, want)
+	}
+
+	// the mock tracer itself is unknown
+	if _, ok := SpanInfoFromContext(opentracing.ContextWithSpan(context.Background(), sp.Span)); ok {
+		t.Error("span info of an unknown tracer")
+	}
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"strconv"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// samplingPriorityKey is the attribute the OpenTelemetry bridge turns the
+// opentracing sampling.priority tag into.
+var samplingPriorityKey = attribute.Key(ext.SamplingPriority)
+
+// PrioritySampler honours the opentracing sampling.priority tag given at
+// span start, like the jaeger tracer does: a positive priority samples the
+// span, zero records it without sampling so that setting a positive
+// priority before the span finishes still exports it. Spans without the
+// tag are left to fallback.
+func PrioritySampler(fallback sdktrace.Sampler) sdktrace.Sampler {
+	return prioritySampler{fallback: fallback}
+}
+
+type prioritySampler struct {
+	fallback sdktrace.Sampler
+}
+
+func (s prioritySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, kv := range p.Attributes {
+		if kv.Key != samplingPriorityKey {
+			continue
+		}
+		decision := sdktrace.RecordOnly
+		if priorityOf(kv.Value) > 0 {
+			decision = sdktrace.RecordAndSample
+		}
+		return sdktrace.SamplingResult{
+			Decision:   decision,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s prioritySampler) Description() string {
+	return "PrioritySampler{" + s.fallback.Description() + "}"
+}
+
+// tailProcessor passes spans which were recorded without being sampled but
+// got a positive sampling.priority before finishing on as sampled.
+type tailProcessor struct {
+	sdktrace.SpanProcessor
+}
+
+func (p tailProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	sc := s.SpanContext()
+	if !sc.IsSampled() {
+		if !raisedPriority(s.Attributes()) {
+			return
+		}
+		s = sampledSpan{ReadOnlySpan: s, sc: sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+	sc trace.SpanContext
+}
+
+func (s sampledSpan) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+func raisedPriority(attrs []attribute.KeyValue) bool {
+	// the last value set wins
+	priority := int64(0)
+	for _, kv := range attrs {
+		if kv.Key == samplingPriorityKey {
+			priority = priorityOf(kv.Value)
+		}
+	}
+	return priority > 0
+}
+
+func priorityOf(v attribute.Value) int64 {
+	switch v.Type() {
+	case attribute.INT64:
+		return v.AsInt64()
+	case attribute.STRING:
+		n, _ := strconv.ParseInt(v.AsString(), 10, 64)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package tracing
+
+import (
+	"io"
+
+	jaegerCfg "github.com/uber/jaeger-client-go/config"
+)
+
+// InitGlobalTracer sets up a jaeger tracer for serviceName as the opentracing
+// global tracer, propagating the trace context in the given header formats,
+// DefaultFormats when none are given.
+//
+// Spans sampled by the server sampling options are forced with the
+// sampling.priority tag, which jaeger marks with the debug flag unless
+// NoDebugFlagOnForcedSampling is set, as done here. The debug flag would
+// force sampling in every downstream service.
+func InitGlobalTracer(serviceName string, cfg *jaegerCfg.Configuration, formats []Format, options ...jaegerCfg.Option) (io.Closer, error) {
+	propagation, err := JaegerOptions(formats...)
+	if err != nil {
+		return nil, err
+	}
+	options = append(append(propagation, jaegerCfg.NoDebugFlagOnForcedSampling(true)), options...)
+	return cfg.InitGlobalTracer(serviceName, options...)
+}